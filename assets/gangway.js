@@ -17,6 +17,36 @@
         btnCopy[i].addEventListener("click", clickCopy)
     }
 
+    var formatDuration = function(seconds) {
+        var h = Math.floor(seconds / 3600);
+        var m = Math.floor(seconds % 3600 / 60);
+        var s = seconds % 60;
+        var pad = function(n) { return n < 10 ? "0" + n : n; };
+        return h > 0 ? h + "h" + pad(m) + "m" + pad(s) + "s" : m > 0 ? m + "m" + pad(s) + "s" : s + "s";
+    };
+
+    var expiries = document.querySelectorAll("[data-expiry]");
+    var countdown = function() {
+        for (var i = 0; i < expiries.length; i++) {
+            var el = expiries[i].querySelector(".countdown");
+            var left = Math.round(parseInt(expiries[i].getAttribute("data-expiry"), 10) - Date.now() / 1000);
+            if (left > 0) {
+                el.textContent = "in " + formatDuration(left);
+            } else {
+                el.textContent = "it has expired, sign in again for a new one";
+                expiries[i].classList.add("red-text");
+            }
+        }
+    };
+    if (expiries.length > 0) {
+        var times = document.querySelectorAll(".token-expiry time");
+        for (var i = 0; i < times.length; i++) {
+            times[i].textContent = new Date(times[i].getAttribute("datetime")).toLocaleString();
+        }
+        countdown();
+        setInterval(countdown, 1000);
+    }
+
     var tabs = document.querySelectorAll(".tabs");
     for (var i = 0; i < tabs.length; i++) {
         M.Tabs.init(tabs[i]);
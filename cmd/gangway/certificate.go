@@ -0,0 +1,196 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// csrApprovalReason marks the CertificateSigningRequests approved by gangway
+	csrApprovalReason = "GangwayAutoApprove"
+	// csrPollInterval is how often gangway checks whether the certificate was issued
+	csrPollInterval = 250 * time.Millisecond
+	// csrTimeout bounds the wait for the signer, well within the write timeout of the server
+	csrTimeout = 5 * time.Second
+)
+
+// clientCertificate is a signed client certificate and its private key, PEM encoded
+type clientCertificate struct {
+	Certificate []byte
+	Key         []byte
+}
+
+// certificateAllowed reports whether a member of groups may obtain a client certificate
+func certificateAllowed(groups []string) bool {
+	for _, group := range groups {
+		for _, allowed := range cfg.CertificateApproveGroups {
+			if group == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// reservedPrefix starts the users and groups reserved by Kubernetes, which are never taken from the IdP
+const reservedPrefix = "system:"
+
+// certificateUsername returns the common name of the certificate for username. Usernames in
+// the system: namespace would let users act as a component of the cluster and are refused.
+func certificateUsername(username string) (string, error) {
+	if strings.HasPrefix(username, reservedPrefix) {
+		return "", fmt.Errorf("%w: the username %s is reserved by Kubernetes", errCredentialNotAllowed, username)
+	}
+	return username, nil
+}
+
+// certificateOrganizations returns the groups to put in the certificate. Groups in the
+// system: namespace are reserved by Kubernetes and never taken from the IdP.
+func certificateOrganizations(groups []string) []string {
+	var orgs []string
+	for _, group := range groups {
+		if !strings.HasPrefix(group, reservedPrefix) {
+			orgs = append(orgs, group)
+		}
+	}
+	return orgs
+}
+
+// issueClientCertificate requests, approves and returns a client certificate with the
+// username as common name and the groups as organizations
+func issueClientCertificate(ctx context.Context, client kubernetes.Interface, username string, groups []string) (*clientCertificate, error) {
+	if !certificateAllowed(groups) {
+		return nil, fmt.Errorf("%w: not a member of the certificateApproveGroups", errCredentialNotAllowed)
+	}
+	commonName, err := certificateUsername(username)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+	orgs := certificateOrganizations(groups)
+	if len(orgs) < len(groups) {
+		log.Warnf("leaving out reserved system: groups from client certificate for %s", username)
+	}
+	request, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName:   commonName,
+			Organization: orgs,
+		},
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate request: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode key: %v", err)
+	}
+
+	expirationSeconds := int32(cfg.CertificateTTL.Seconds())
+	csr, err := client.CertificatesV1().CertificateSigningRequests().Create(ctx, &certificatesv1.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "gangway-",
+			Labels:       map[string]string{"app.kubernetes.io/managed-by": "gangway"},
+		},
+		Spec: certificatesv1.CertificateSigningRequestSpec{
+			Request:           pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: request}),
+			SignerName:        cfg.CertificateSignerName,
+			ExpirationSeconds: &expirationSeconds,
+			Usages:            []certificatesv1.KeyUsage{certificatesv1.UsageDigitalSignature, certificatesv1.UsageClientAuth},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CertificateSigningRequest: %v", err)
+	}
+	defer func() {
+		// the certificate is handed out once, there is no need to keep the request around
+		err := client.CertificatesV1().CertificateSigningRequests().Delete(context.Background(), csr.Name, metav1.DeleteOptions{})
+		if err != nil {
+			log.Warnf("failed to delete CertificateSigningRequest %s: %v", csr.Name, err)
+		}
+	}()
+
+	csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+		Type:           certificatesv1.CertificateApproved,
+		Status:         corev1.ConditionTrue,
+		Reason:         csrApprovalReason,
+		Message:        fmt.Sprintf("approved by gangway for %s", username),
+		LastUpdateTime: metav1.Now(),
+	})
+	csr, err = client.CertificatesV1().CertificateSigningRequests().UpdateApproval(ctx, csr.Name, csr, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to approve CertificateSigningRequest: %v", err)
+	}
+
+	certificate, err := waitForCertificate(ctx, client, csr.Name)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("issued client certificate for %s through CertificateSigningRequest %s", username, csr.Name)
+
+	return &clientCertificate{
+		Certificate: certificate,
+		Key:         pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+// waitForCertificate polls the CertificateSigningRequest until the signer issued the certificate
+func waitForCertificate(ctx context.Context, client kubernetes.Interface, name string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, csrTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(csrPollInterval)
+	defer ticker.Stop()
+
+	for {
+		csr, err := client.CertificatesV1().CertificateSigningRequests().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get CertificateSigningRequest %s: %v", name, err)
+		}
+		for _, c := range csr.Status.Conditions {
+			if (c.Type == certificatesv1.CertificateDenied || c.Type == certificatesv1.CertificateFailed) && c.Status == corev1.ConditionTrue {
+				return nil, fmt.Errorf("CertificateSigningRequest %s %s: %s", name, strings.ToLower(string(c.Type)), c.Message)
+			}
+		}
+		if len(csr.Status.Certificate) > 0 {
+			return csr.Status.Certificate, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("CertificateSigningRequest %s was not signed in time, is the signer %s running?", name, cfg.CertificateSignerName)
+		case <-ticker.C:
+		}
+	}
+}
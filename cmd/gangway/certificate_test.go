@@ -0,0 +1,228 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jcrood/gangway/internal/config"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// fakeSigner makes the fake clientset act like the signer of the cluster, signing
+// CertificateSigningRequests as soon as they are approved
+func fakeSigner(t *testing.T, client *fake.Clientset, deny bool) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	client.PrependReactor("update", "certificatesigningrequests", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "approval" {
+			return false, nil, nil
+		}
+		csr := action.(k8stesting.UpdateAction).GetObject().(*certificatesv1.CertificateSigningRequest).DeepCopy()
+		if deny {
+			csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+				Type:    certificatesv1.CertificateFailed,
+				Status:  corev1.ConditionTrue,
+				Message: "signer refused",
+			})
+		} else {
+			block, _ := pem.Decode(csr.Spec.Request)
+			request, err := x509.ParseCertificateRequest(block.Bytes)
+			if err != nil {
+				return true, nil, err
+			}
+			certDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+				SerialNumber: big.NewInt(2),
+				Subject:      request.Subject,
+				NotBefore:    time.Now(),
+				NotAfter:     time.Now().Add(time.Duration(*csr.Spec.ExpirationSeconds) * time.Second),
+				ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			}, ca, request.PublicKey, caKey)
+			if err != nil {
+				return true, nil, err
+			}
+			csr.Status.Certificate = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+		}
+		err := client.Tracker().Update(certificatesv1.SchemeGroupVersion.WithResource("certificatesigningrequests"), csr, "")
+		return true, csr, err
+	})
+
+	// the fake clientset does not implement generateName
+	client.PrependReactor("create", "certificatesigningrequests", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		csr := action.(k8stesting.CreateAction).GetObject().(*certificatesv1.CertificateSigningRequest)
+		if csr.Name == "" {
+			csr.Name = csr.GenerateName + "test"
+		}
+		return false, nil, nil
+	})
+}
+
+func TestIssueClientCertificate(t *testing.T) {
+	cfg = &config.Config{
+		CertificateSignerName:    "kubernetes.io/kube-apiserver-client",
+		CertificateTTL:           config.Duration{Duration: time.Hour},
+		CertificateApproveGroups: []string{"breakglass"},
+	}
+	client := fake.NewSimpleClientset()
+	fakeSigner(t, client, false)
+
+	cert, err := issueClientCertificate(context.Background(), client, "alice", []string{"dev", "breakglass", "system:masters"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	block, _ := pem.Decode(cert.Certificate)
+	if block == nil {
+		t.Fatalf("expected a PEM encoded certificate, got %s", cert.Certificate)
+	}
+	issued, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	if issued.Subject.CommonName != "alice" {
+		t.Errorf("expected the username as common name, got %s", issued.Subject.CommonName)
+	}
+	if !reflect.DeepEqual(issued.Subject.Organization, []string{"dev", "breakglass"}) {
+		t.Errorf("expected the groups without system: groups as organizations, got %v", issued.Subject.Organization)
+	}
+	if issued.NotAfter.After(time.Now().Add(time.Hour + time.Minute)) {
+		t.Errorf("expected the certificate to expire within the TTL, got %s", issued.NotAfter)
+	}
+
+	keyBlock, _ := pem.Decode(cert.Key)
+	if keyBlock == nil {
+		t.Fatalf("expected a PEM encoded key, got %s", cert.Key)
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse key: %v", err)
+	}
+	if !key.PublicKey.Equal(issued.PublicKey) {
+		t.Errorf("key does not match the certificate")
+	}
+
+	var approved bool
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "update" && action.GetSubresource() == "approval" {
+			csr := action.(k8stesting.UpdateAction).GetObject().(*certificatesv1.CertificateSigningRequest)
+			approved = csr.Status.Conditions[0].Reason == csrApprovalReason
+			if csr.Spec.SignerName != cfg.CertificateSignerName {
+				t.Errorf("wrong signer name %s", csr.Spec.SignerName)
+			}
+		}
+	}
+	if !approved {
+		t.Errorf("expected gangway to approve the request, got actions %v", client.Actions())
+	}
+	csrs, _ := client.CertificatesV1().CertificateSigningRequests().List(context.Background(), metav1.ListOptions{})
+	if len(csrs.Items) != 0 {
+		t.Errorf("expected the request to be deleted, got %v", csrs.Items)
+	}
+}
+
+func TestIssueClientCertificateNotAllowed(t *testing.T) {
+	cfg = &config.Config{
+		CertificateSignerName:    "kubernetes.io/kube-apiserver-client",
+		CertificateTTL:           config.Duration{Duration: time.Hour},
+		CertificateApproveGroups: []string{"breakglass"},
+	}
+	client := fake.NewSimpleClientset()
+
+	_, err := issueClientCertificate(context.Background(), client, "bob", []string{"dev"})
+	if !errors.Is(err, errCredentialNotAllowed) {
+		t.Errorf("expected errCredentialNotAllowed, got %v", err)
+	}
+	if len(client.Actions()) != 0 {
+		t.Errorf("expected no requests to the cluster, got %v", client.Actions())
+	}
+}
+
+func TestIssueClientCertificateReservedUsername(t *testing.T) {
+	cfg = &config.Config{
+		CertificateSignerName:    "kubernetes.io/kube-apiserver-client",
+		CertificateTTL:           config.Duration{Duration: time.Hour},
+		CertificateApproveGroups: []string{"breakglass"},
+	}
+
+	for _, username := range []string{"system:kube-controller-manager", "system:node:foo"} {
+		client := fake.NewSimpleClientset()
+		_, err := issueClientCertificate(context.Background(), client, username, []string{"breakglass"})
+		if !errors.Is(err, errCredentialNotAllowed) {
+			t.Errorf("%s: expected errCredentialNotAllowed, got %v", username, err)
+		}
+		if len(client.Actions()) != 0 {
+			t.Errorf("%s: expected no requests to the cluster, got %v", username, client.Actions())
+		}
+	}
+}
+
+func TestIssueClientCertificateFailed(t *testing.T) {
+	cfg = &config.Config{
+		CertificateSignerName:    "kubernetes.io/kube-apiserver-client",
+		CertificateTTL:           config.Duration{Duration: time.Hour},
+		CertificateApproveGroups: []string{"breakglass"},
+	}
+	client := fake.NewSimpleClientset()
+	fakeSigner(t, client, true)
+
+	_, err := issueClientCertificate(context.Background(), client, "alice", []string{"breakglass"})
+	if err == nil {
+		t.Errorf("expected an error for a failed request")
+	}
+}
+
+func TestKubeConfigAuthInfoCertificate(t *testing.T) {
+	info := &userInfo{ClientCertificate: "cert", ClientKey: "key", IDToken: "id"}
+	authInfo := kubeConfigAuthInfo(info)
+	if string(authInfo.ClientCertificateData) != "cert" || string(authInfo.ClientKeyData) != "key" {
+		t.Errorf("expected the client certificate, got %+v", authInfo)
+	}
+	if authInfo.AuthProvider != nil || authInfo.Exec != nil {
+		t.Errorf("expected no other credentials, got %+v", authInfo)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// standardClaims are the claims defined by OpenID Connect Core 1.0, shown apart from the custom ones
+var standardClaims = map[string]bool{
+	"iss": true, "sub": true, "aud": true, "exp": true, "iat": true, "auth_time": true, "nonce": true,
+	"acr": true, "amr": true, "azp": true, "at_hash": true, "c_hash": true, "sid": true,
+	"name": true, "given_name": true, "family_name": true, "middle_name": true, "nickname": true,
+	"preferred_username": true, "profile": true, "picture": true, "website": true, "email": true,
+	"email_verified": true, "gender": true, "birthdate": true, "zoneinfo": true, "locale": true,
+	"phone_number": true, "phone_number_verified": true, "address": true, "updated_at": true,
+}
+
+// redactedClaim replaces the value of the claims listed in redactClaims
+const redactedClaim = "[redacted]"
+
+// stringsClaim returns a claim holding a list of strings, such as the groups claim.
+// A claim with a single string value is returned as a list with one element.
+func stringsClaim(claims map[string]interface{}, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// timeClaim returns a claim holding seconds since the epoch, such as auth_time, in UTC.
+// A missing or malformed claim yields the zero time.
+func timeClaim(claims map[string]interface{}, name string) time.Time {
+	switch v := claims[name].(type) {
+	case float64:
+		return time.Unix(int64(v), 0).UTC()
+	case int64:
+		return time.Unix(v, 0).UTC()
+	case json.Number:
+		if seconds, err := v.Int64(); err == nil {
+			return time.Unix(seconds, 0).UTC()
+		}
+	}
+	return time.Time{}
+}
+
+// claimsYAML renders the standard and the custom claims as YAML documents, with the
+// values of redacted claims masked. A group without claims is rendered as "".
+func claimsYAML(claims map[string]interface{}) (string, string, error) {
+	redacted := make(map[string]bool, len(cfg.RedactClaims))
+	for _, name := range cfg.RedactClaims {
+		redacted[name] = true
+	}
+
+	standard := make(map[string]interface{})
+	custom := make(map[string]interface{})
+	for name, value := range claims {
+		if redacted[name] {
+			value = redactedClaim
+		}
+		if standardClaims[name] {
+			standard[name] = value
+		} else {
+			custom[name] = value
+		}
+	}
+
+	var docs []string
+	for _, group := range []map[string]interface{}{standard, custom} {
+		if len(group) == 0 {
+			docs = append(docs, "")
+			continue
+		}
+		doc, err := yaml.Marshal(group)
+		if err != nil {
+			return "", "", err
+		}
+		docs = append(docs, strings.TrimRight(string(doc), "\n"))
+	}
+	return docs[0], docs[1], nil
+}
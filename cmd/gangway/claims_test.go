@@ -0,0 +1,130 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jcrood/gangway/internal/config"
+)
+
+func TestStringsClaim(t *testing.T) {
+	claims := map[string]interface{}{
+		"groups": []interface{}{"dev", "admin", 42},
+		"team":   "platform",
+		"admin":  true,
+	}
+
+	tests := map[string]struct {
+		name string
+		want []string
+	}{
+		"list":    {name: "groups", want: []string{"dev", "admin"}},
+		"single":  {name: "team", want: []string{"platform"}},
+		"other":   {name: "admin", want: nil},
+		"missing": {name: "nope", want: nil},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := stringsClaim(claims, tc.name)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("stringsClaim(%q): want %v, got %v", tc.name, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestTimeClaim(t *testing.T) {
+	authTime := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	claims := map[string]interface{}{
+		"auth_time": float64(authTime.Unix()),
+		"number":    json.Number("1654084800"),
+		"text":      "yesterday",
+	}
+
+	if got := timeClaim(claims, "auth_time"); !got.Equal(authTime) || got.Location() != time.UTC {
+		t.Errorf("want %s, got %s", authTime, got)
+	}
+	if got := timeClaim(claims, "number"); !got.Equal(authTime) {
+		t.Errorf("want %s, got %s", authTime, got)
+	}
+	for _, name := range []string{"text", "missing"} {
+		if got := timeClaim(claims, name); !got.IsZero() {
+			t.Errorf("expected the zero time for %s, got %s", name, got)
+		}
+	}
+}
+
+func TestExpiresIn(t *testing.T) {
+	info := &userInfo{Expiry: time.Now().Add(90 * time.Minute)}
+	if left := info.ExpiresIn(); left < 89*time.Minute || left > 90*time.Minute || left%time.Second != 0 {
+		t.Errorf("expected about 90 minutes in whole seconds, got %s", left)
+	}
+}
+
+func TestClaimsYAML(t *testing.T) {
+	cfg = &config.Config{RedactClaims: []string{"email", "secret_id"}}
+	claims := map[string]interface{}{
+		"sub":       "alice",
+		"email":     "alice@example.com",
+		"exp":       float64(1700000000),
+		"aud":       []interface{}{"gangway", "kubernetes"},
+		"secret_id": "hunter2",
+		"tenant": map[string]interface{}{
+			"id":    json.Number("42"),
+			"roles": []interface{}{"admin", map[string]interface{}{"scope": "read"}},
+		},
+	}
+
+	standard, custom, err := claimsYAML(claims)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wantStandard := `aud:
+- gangway
+- kubernetes
+email: '[redacted]'
+exp: 1700000000
+sub: alice`
+	if standard != wantStandard {
+		t.Errorf("wrong standard claims:\n%s\nwant:\n%s", standard, wantStandard)
+	}
+
+	wantCustom := `secret_id: '[redacted]'
+tenant:
+  id: 42
+  roles:
+  - admin
+  - scope: read`
+	if custom != wantCustom {
+		t.Errorf("wrong custom claims:\n%s\nwant:\n%s", custom, wantCustom)
+	}
+}
+
+func TestClaimsYAMLEmptyGroup(t *testing.T) {
+	cfg = &config.Config{}
+	standard, custom, err := claimsYAML(map[string]interface{}{"sub": "alice"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if standard != "sub: alice" || custom != "" {
+		t.Errorf("expected only standard claims, got %q and %q", standard, custom)
+	}
+}
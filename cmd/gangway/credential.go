@@ -0,0 +1,142 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jcrood/gangway/internal/config"
+)
+
+// Kinds of credentials put in kubeconfigs, passed to the templates as .CredentialKind
+const (
+	credentialKindIDToken        = "id_token"
+	credentialKindExecPlugin     = "exec_plugin"
+	credentialKindExchanged      = "exchanged_token"
+	credentialKindServiceAccount = "serviceaccount_token"
+	credentialKindCertificate    = "certificate"
+)
+
+// errCredentialNotAllowed is returned when the policy does not grant the user credentials for the cluster
+var errCredentialNotAllowed = errors.New("not allowed to obtain credentials for the cluster")
+
+// usesCredentialSession reports whether kubeconfigs carry credentials issued after login
+// instead of the tokens of the login itself. These are kept in the gangway_credential session.
+func usesCredentialSession() bool {
+	return cfg.TokenExchangeAudience != "" || cfg.CredentialBackend != config.CredentialBackendOIDC
+}
+
+// issueCredential obtains the credentials for the cluster after login, returned as session values
+func issueCredential(ctx context.Context, rawIDToken string, claims map[string]interface{}) (map[interface{}]interface{}, error) {
+	if cfg.CredentialBackend != config.CredentialBackendOIDC {
+		username, ok := claims[cfg.UsernameClaim].(string)
+		if !ok {
+			return nil, errors.New("could not parse username claim")
+		}
+		data := newClaimsData(username, claims)
+
+		if cfg.CredentialBackend == config.CredentialBackendServiceAccount {
+			token, expiry, err := issueServiceAccountToken(ctx, kubeClient, username, data.Groups)
+			if err != nil {
+				return nil, err
+			}
+			return map[interface{}]interface{}{
+				"bearer_token": token,
+				"expiry":       expiry.Unix(),
+			}, nil
+		}
+
+		cert, err := issueClientCertificate(ctx, kubeClient, username, data.Groups)
+		if err != nil {
+			return nil, err
+		}
+		return map[interface{}]interface{}{
+			"certificate": string(cert.Certificate),
+			"key":         string(cert.Key),
+		}, nil
+	}
+
+	exchanged, err := exchangeToken(ctx, transportConfig.HTTPClient, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("audience %s: %v", cfg.TokenExchangeAudience, err)
+	}
+	values := map[interface{}]interface{}{"token": exchanged.Token}
+	if !exchanged.Expiry.IsZero() {
+		values["expiry"] = exchanged.Expiry.Unix()
+	}
+	return values, nil
+}
+
+// applyCredential replaces the tokens in info with the credentials from the session,
+// returning false when these are missing
+func applyCredential(info *userInfo, values map[interface{}]interface{}) bool {
+	// sessions from before the expiry was kept have none
+	info.CredentialExpiry = time.Time{}
+	if expiry, ok := values["expiry"].(int64); ok {
+		info.CredentialExpiry = time.Unix(expiry, 0).UTC()
+	}
+
+	if cfg.CredentialBackend == config.CredentialBackendServiceAccount {
+		token, ok := values["bearer_token"].(string)
+		if !ok {
+			return false
+		}
+		info.BearerToken = token
+		info.IDToken = ""
+		info.RefreshToken = ""
+		info.CredentialKind = credentialKindServiceAccount
+		return true
+	}
+	if cfg.CredentialBackend == config.CredentialBackendCertificate {
+		cert, ok := values["certificate"].(string)
+		if !ok {
+			return false
+		}
+		key, ok := values["key"].(string)
+		if !ok {
+			return false
+		}
+		info.ClientCertificate = cert
+		info.ClientKey = key
+		info.IDToken = ""
+		info.RefreshToken = ""
+		info.CredentialKind = credentialKindCertificate
+		if issued, err := parseCertificate([]byte(cert)); err == nil {
+			info.CredentialExpiry = issued.NotAfter.UTC()
+		}
+		return true
+	}
+
+	token, ok := values["token"].(string)
+	if !ok {
+		return false
+	}
+	// refresh tokens, of the login or the exchange, are bound to gangway's client and would
+	// refresh into tokens for gangway's audience, so kubectl gets none and needs no secret
+	info.RefreshToken = ""
+	info.ClientSecret = ""
+	info.CredentialKind = credentialKindExchanged
+	if cfg.TokenExchangeTokenType == config.TokenTypeAccessToken {
+		// access tokens may well be opaque, the oidc auth provider only takes JWTs
+		info.BearerToken = token
+		info.IDToken = ""
+		return true
+	}
+	info.IDToken = token
+	return true
+}
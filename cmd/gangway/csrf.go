@@ -0,0 +1,108 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"mime"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// csrfField is the form field carrying the CSRF token
+	csrfField = "csrf_token"
+	// csrfHeader carries the CSRF token for requests that are not forms
+	csrfHeader = "X-CSRF-Token"
+)
+
+// csrfToken returns the CSRF token of the session, creating one for sessions without
+func csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	session, err := gangwayUserSession.Session.Get(r, "gangway")
+	if err != nil {
+		return "", err
+	}
+	if token, ok := session.Values[csrfField].(string); ok && token != "" {
+		return token, nil
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	session.Values[csrfField] = token
+	if err := session.Save(r, w); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// csrfSafe reports whether a request cannot be forged by another site. Browsers only send
+// the content types of forms cross-site without asking first, which gangway never allows.
+func csrfSafe(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "application/x-www-form-urlencoded", "multipart/form-data", "text/plain":
+		return false
+	}
+	return true
+}
+
+// csrfProtect rejects state-changing requests that do not carry the CSRF token of the
+// session, either in the csrf_token form field or in the X-CSRF-Token header
+func csrfProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if csrfSafe(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session, err := gangwayUserSession.Session.Get(r, "gangway")
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		expected, _ := session.Values[csrfField].(string)
+
+		sent := r.Header.Get(csrfHeader)
+		if sent == "" {
+			// forms are read here already, limited like the largest form gangway accepts
+			r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+			if err := r.ParseMultipartForm(maxUploadSize); err != nil && err != http.ErrNotMultipart {
+				http.Error(w, "Could not read form", http.StatusBadRequest)
+				return
+			}
+			sent = r.PostFormValue(csrfField)
+		}
+
+		if expected == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(expected)) != 1 {
+			log.Warnf("rejected %s %s without a valid CSRF token from %s", r.Method, r.URL.Path, clientAddr(r))
+			http.Error(w, "Invalid CSRF token, reload the page and try again", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
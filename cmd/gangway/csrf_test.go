@@ -0,0 +1,174 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jcrood/gangway/internal/config"
+)
+
+// csrfRequest returns a request carrying a session with the CSRF token "secret"
+func csrfRequest(t *testing.T, method, contentType string, body string) *http.Request {
+	t.Helper()
+	session := requestWithSession(t, "/logout", map[string]interface{}{csrfField: "secret"})
+	req := httptest.NewRequest(method, "/logout", strings.NewReader(body))
+	for _, c := range session.Cookies() {
+		req.AddCookie(c)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return req
+}
+
+func TestCSRFProtect(t *testing.T) {
+	cfg = &config.Config{}
+	testInit()
+
+	var multipartBody bytes.Buffer
+	mw := multipart.NewWriter(&multipartBody)
+	_ = mw.WriteField(csrfField, "secret")
+	_ = mw.Close()
+
+	tests := map[string]struct {
+		req  func(t *testing.T) *http.Request
+		want int
+	}{
+		"get": {
+			req:  func(t *testing.T) *http.Request { return csrfRequest(t, "GET", "", "") },
+			want: http.StatusOK,
+		},
+		"form with token": {
+			req: func(t *testing.T) *http.Request {
+				return csrfRequest(t, "POST", "application/x-www-form-urlencoded", url.Values{csrfField: {"secret"}}.Encode())
+			},
+			want: http.StatusOK,
+		},
+		"multipart form with token": {
+			req: func(t *testing.T) *http.Request {
+				return csrfRequest(t, "POST", mw.FormDataContentType(), multipartBody.String())
+			},
+			want: http.StatusOK,
+		},
+		"header with token": {
+			req: func(t *testing.T) *http.Request {
+				req := csrfRequest(t, "POST", "text/plain", "")
+				req.Header.Set(csrfHeader, "secret")
+				return req
+			},
+			want: http.StatusOK,
+		},
+		"form without token": {
+			req: func(t *testing.T) *http.Request {
+				return csrfRequest(t, "POST", "application/x-www-form-urlencoded", "")
+			},
+			want: http.StatusForbidden,
+		},
+		"form with wrong token": {
+			req: func(t *testing.T) *http.Request {
+				return csrfRequest(t, "POST", "application/x-www-form-urlencoded", url.Values{csrfField: {"guess"}}.Encode())
+			},
+			want: http.StatusForbidden,
+		},
+		"token in the query": {
+			req: func(t *testing.T) *http.Request {
+				req := csrfRequest(t, "POST", "application/x-www-form-urlencoded", "")
+				req.URL.RawQuery = url.Values{csrfField: {"secret"}}.Encode()
+				return req
+			},
+			want: http.StatusForbidden,
+		},
+		"plain text without token": {
+			req:  func(t *testing.T) *http.Request { return csrfRequest(t, "POST", "text/plain", "") },
+			want: http.StatusForbidden,
+		},
+		"no content type": {
+			req:  func(t *testing.T) *http.Request { return csrfRequest(t, "POST", "", "") },
+			want: http.StatusForbidden,
+		},
+		"yaml upload": {
+			req:  func(t *testing.T) *http.Request { return csrfRequest(t, "POST", "application/yaml", "apiVersion: v1") },
+			want: http.StatusOK,
+		},
+		"session without token": {
+			req: func(t *testing.T) *http.Request {
+				req := requestWithSession(t, "/logout", map[string]interface{}{})
+				req.Method = "POST"
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				req.Body = http.NoBody
+				return req
+			},
+			want: http.StatusForbidden,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			rsp := httptest.NewRecorder()
+			csrfProtect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})).ServeHTTP(rsp, tc.req(t))
+			if rsp.Code != tc.want {
+				t.Errorf("want status %d, got %d: %s", tc.want, rsp.Code, rsp.Body.String())
+			}
+		})
+	}
+}
+
+func TestCSRFToken(t *testing.T) {
+	cfg = &config.Config{}
+	testInit()
+
+	req := requestWithSession(t, "/commandline", map[string]interface{}{})
+	rsp := httptest.NewRecorder()
+	token, err := csrfToken(rsp, req)
+	if err != nil || len(token) < 32 {
+		t.Fatalf("expected a new token, got %q (%v)", token, err)
+	}
+
+	// the token is kept in the session
+	req = httptest.NewRequest("GET", "/commandline", nil)
+	for _, c := range rsp.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	again, err := csrfToken(httptest.NewRecorder(), req)
+	if err != nil || again != token {
+		t.Errorf("expected the token of the session, got %q (%v)", again, err)
+	}
+}
+
+func TestLogoutRequiresPost(t *testing.T) {
+	cfg = &config.Config{}
+	testInit()
+
+	rsp := httptest.NewRecorder()
+	logoutHandler(rsp, httptest.NewRequest("GET", "/logout", nil))
+	if rsp.Code != http.StatusMethodNotAllowed || rsp.Header().Get("Allow") != http.MethodPost {
+		t.Errorf("expected GET to be refused, got %d", rsp.Code)
+	}
+
+	rsp = httptest.NewRecorder()
+	logoutHandler(rsp, httptest.NewRequest("POST", "/logout", nil))
+	if rsp.Code != http.StatusSeeOther {
+		t.Errorf("expected POST to log out, got %d", rsp.Code)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/jcrood/gangway/internal/onetime"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxDownloadLinks limits the number of unredeemed download links
+	maxDownloadLinks = 10000
+	// maxDownloadLinksPerUser limits the unredeemed download links of a user, older links stop
+	// working when new ones are minted. A visit of the commandline page mints up to two.
+	maxDownloadLinksPerUser = 4
+)
+
+// downloadLinks holds the snapshots behind one-time download links, it is nil when
+// download links are disabled
+var downloadLinks *onetime.Store
+
+// downloadSnapshot is the record behind a one-time download link
+type downloadSnapshot struct {
+	Info *userInfo
+}
+
+// mintDownloadLink stores a snapshot of info and returns the one-time URL to fetch
+// its kubeconfig from
+func mintDownloadLink(r *http.Request, info *userInfo) (string, error) {
+	d, err := json.Marshal(&downloadSnapshot{Info: info})
+	if err != nil {
+		return "", err
+	}
+
+	token, err := downloadLinks.Put(info.Username, d)
+	if err != nil {
+		return "", err
+	}
+	return externalURL(r, "/dl/"+token), nil
+}
+
+// mintDownloadLinkOrLog returns a new download link for info, or an empty string
+// after logging the error when none could be created
+func mintDownloadLinkOrLog(r *http.Request, info *userInfo) string {
+	link, err := mintDownloadLink(r, info)
+	if err != nil {
+		log.Errorf("Failed to create download link: %v", err)
+		return ""
+	}
+	return link
+}
+
+// downloadHandler serves the kubeconfig behind a one-time download link. The link
+// is consumed on the first fetch, whether it succeeds or not.
+func downloadHandler(w http.ResponseWriter, r *http.Request) {
+	// only GET redeems a link, so link previews using HEAD do not consume it
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := strings.TrimPrefix(r.URL.Path, cfg.HTTPPath+"/dl/")
+	if downloadLinks == nil || token == "" || strings.Contains(token, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := negotiateFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	d, err := downloadLinks.Take(token)
+	if err != nil {
+		log.Warnf("Failed to redeem download link from %s: %v", clientAddr(r), err)
+		http.Error(w, "This download link is invalid, expired or was already used", http.StatusNotFound)
+		return
+	}
+
+	snapshot := &downloadSnapshot{}
+	if err = json.Unmarshal(d, snapshot); err != nil || snapshot.Info == nil {
+		log.Errorf("Failed to read download link record: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	log.Infof("Download link for %s redeemed from %s", snapshot.Info.Username, clientAddr(r))
+	writeKubeConfig(w, snapshot.Info, f)
+}
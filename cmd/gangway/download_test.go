@@ -0,0 +1,87 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jcrood/gangway/internal/config"
+	"github.com/jcrood/gangway/internal/onetime"
+)
+
+func TestDownloadLink(t *testing.T) {
+	cfg = &config.Config{
+		HTTPPath:    "/gangway",
+		RedirectURL: "https://gangway.example.com/gangway/callback",
+	}
+	downloadLinks = onetime.NewStore(time.Minute, 10, maxDownloadLinksPerUser)
+	defer func() { downloadLinks = nil }()
+
+	info := &userInfo{
+		ClusterName:    "prod-eu",
+		Username:       "alice",
+		KubeCfgCluster: "prod-eu",
+		KubeCfgContext: "prod-eu",
+		KubeCfgUser:    "alice@prod-eu",
+		IDToken:        "id-token",
+		Contexts:       []kubeContext{{Name: "prod-eu"}},
+	}
+
+	link, err := mintDownloadLink(httptest.NewRequest("GET", "/commandline", nil), info)
+	if err != nil {
+		t.Fatalf("mintDownloadLink failed: %v", err)
+	}
+	prefix := "https://gangway.example.com/gangway/dl/"
+	if !strings.HasPrefix(link, prefix) {
+		t.Fatalf("expected link to start with %q, got %q", prefix, link)
+	}
+	path := strings.TrimPrefix(link, "https://gangway.example.com")
+
+	tests := []struct {
+		name               string
+		method             string
+		target             string
+		expectedStatusCode int
+	}{
+		{"preview does not consume", "HEAD", path, http.StatusMethodNotAllowed},
+		{"unknown format does not consume", "GET", path + "?format=toml", http.StatusBadRequest},
+		{"redeem", "GET", path + "?format=json", http.StatusOK},
+		{"redeem again", "GET", path, http.StatusNotFound},
+		{"unknown token", "GET", "/gangway/dl/bogus", http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rsp := httptest.NewRecorder()
+			http.HandlerFunc(downloadHandler).ServeHTTP(rsp, httptest.NewRequest(tc.method, tc.target, nil))
+
+			if rsp.Code != tc.expectedStatusCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", rsp.Code, tc.expectedStatusCode)
+			}
+			if rsp.Code == http.StatusOK {
+				if ct := rsp.Header().Get("Content-Type"); ct != jsonFormat.ContentType {
+					t.Errorf("expected content type %q, got %q", jsonFormat.ContentType, ct)
+				}
+				if !strings.Contains(rsp.Body.String(), "id-token") {
+					t.Errorf("expected kubeconfig with the snapshotted token, got %s", rsp.Body.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/jcrood/gangway/internal/config"
+)
+
+// Token exchange as defined in RFC 8693
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeIDToken       = "urn:ietf:params:oauth:token-type:id_token"
+	tokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// maxTokenResponseSize limits the size of the token endpoint response that is read
+const maxTokenResponseSize = 1 << 20
+
+// exchangeVerifier verifies exchanged ID tokens against the audience of the cluster
+var exchangeVerifier *oidc.IDTokenVerifier
+
+// exchangedToken is a token issued for the audience of the cluster
+type exchangedToken struct {
+	Token string
+	// Expiry is zero when the identity provider did not tell
+	Expiry time.Time
+}
+
+// tokenExchangeResponse is the response of the token endpoint, including errors
+type tokenExchangeResponse struct {
+	AccessToken      string `json:"access_token"`
+	IssuedTokenType  string `json:"issued_token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// requestedTokenType maps the configured token type to its URI
+func requestedTokenType(tokenType string) string {
+	if tokenType == config.TokenTypeAccessToken {
+		return tokenTypeAccessToken
+	}
+	return tokenTypeIDToken
+}
+
+// exchangeToken trades the ID token issued to gangway for a token with the audience of the cluster
+func exchangeToken(ctx context.Context, client *http.Client, rawIDToken string) (*exchangedToken, error) {
+	requested := requestedTokenType(cfg.TokenExchangeTokenType)
+	form := url.Values{
+		"grant_type":           {tokenExchangeGrantType},
+		"subject_token":        {rawIDToken},
+		"subject_token_type":   {tokenTypeIDToken},
+		"requested_token_type": {requested},
+		"audience":             {cfg.TokenExchangeAudience},
+	}
+	if oauth2Cfg.ClientSecret == "" {
+		form.Set("client_id", oauth2Cfg.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, oauth2Cfg.Endpoint.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if oauth2Cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(oauth2Cfg.ClientID), url.QueryEscape(oauth2Cfg.ClientSecret))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token exchange failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxTokenResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("token exchange failed: %v", err)
+	}
+
+	var result tokenExchangeResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("token exchange failed: %s: cannot parse response: %v", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || result.Error != "" {
+		return nil, fmt.Errorf("token exchange failed: %s: %s %s", resp.Status, result.Error, result.ErrorDescription)
+	}
+	if result.AccessToken == "" {
+		return nil, fmt.Errorf("token exchange failed: no token issued")
+	}
+	if result.IssuedTokenType != "" && result.IssuedTokenType != requested {
+		return nil, fmt.Errorf("token exchange failed: requested %s, got %s", requested, result.IssuedTokenType)
+	}
+
+	exchanged := &exchangedToken{Token: result.AccessToken}
+	if result.ExpiresIn > 0 {
+		exchanged.Expiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second).UTC()
+	}
+
+	// ID tokens are checked to be valid for the cluster, access tokens may well be opaque
+	if requested == tokenTypeIDToken {
+		idToken, err := exchangeVerifier.Verify(ctx, result.AccessToken)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify exchanged token: %v", err)
+		}
+		exchanged.Expiry = idToken.Expiry.UTC()
+	}
+	return exchanged, nil
+}
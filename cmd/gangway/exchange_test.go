@@ -0,0 +1,201 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/jcrood/gangway/internal/config"
+)
+
+const testIssuer = "https://idp.example.com"
+
+// signedTestToken returns an RS256 JWT with the given claims
+func signedTestToken(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func testClusterToken(t *testing.T, key *rsa.PrivateKey, audience string) string {
+	return signedTestToken(t, key, map[string]interface{}{
+		"iss": testIssuer,
+		"sub": "alice",
+		"aud": audience,
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"iat": time.Now().Unix(),
+	})
+}
+
+func TestExchangeToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusterToken := testClusterToken(t, key, "cluster")
+
+	tests := map[string]struct {
+		tokenType     string
+		status        int
+		response      map[string]string
+		expectedToken string
+		expectExpiry  bool
+		expectError   bool
+	}{
+		"id token": {
+			tokenType: config.TokenTypeIDToken,
+			status:    http.StatusOK,
+			response: map[string]string{
+				"access_token":      clusterToken,
+				"issued_token_type": tokenTypeIDToken,
+				"token_type":        "N_A",
+				"refresh_token":     "cluster-refresh",
+			},
+			expectedToken: clusterToken,
+			expectExpiry:  true,
+		},
+		"id token for the wrong audience": {
+			tokenType: config.TokenTypeIDToken,
+			status:    http.StatusOK,
+			response: map[string]string{
+				"access_token":      testClusterToken(t, key, "gangway"),
+				"issued_token_type": tokenTypeIDToken,
+			},
+			expectError: true,
+		},
+		"opaque access token": {
+			tokenType: config.TokenTypeAccessToken,
+			status:    http.StatusOK,
+			response: map[string]string{
+				"access_token":      "opaque",
+				"issued_token_type": tokenTypeAccessToken,
+				"token_type":        "Bearer",
+			},
+			expectedToken: "opaque",
+		},
+		"other token type issued": {
+			tokenType: config.TokenTypeIDToken,
+			status:    http.StatusOK,
+			response: map[string]string{
+				"access_token":      "opaque",
+				"issued_token_type": tokenTypeAccessToken,
+			},
+			expectError: true,
+		},
+		"refused": {
+			tokenType: config.TokenTypeIDToken,
+			status:    http.StatusBadRequest,
+			response: map[string]string{
+				"error":             "invalid_target",
+				"error_description": "audience not allowed",
+			},
+			expectError: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var form map[string][]string
+			var user, password string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				r.ParseForm()
+				form = r.PostForm
+				user, password, _ = r.BasicAuth()
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tc.status)
+				json.NewEncoder(w).Encode(tc.response)
+			}))
+			defer server.Close()
+
+			cfg = &config.Config{TokenExchangeAudience: "cluster", TokenExchangeTokenType: tc.tokenType}
+			testInit()
+			oauth2Cfg.ClientID = "gangway"
+			oauth2Cfg.Endpoint.TokenURL = server.URL
+			exchangeVerifier = oidc.NewVerifier(testIssuer, &oidc.StaticKeySet{PublicKeys: []crypto.PublicKey{&key.PublicKey}}, &oidc.Config{ClientID: "cluster"})
+
+			exchanged, err := exchangeToken(context.Background(), server.Client(), "gangway-id-token")
+			if tc.expectError {
+				if err == nil {
+					t.Fatalf("expected an error but got token %v", exchanged)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if exchanged.Token != tc.expectedToken {
+				t.Errorf("wrong token: want %s, got %s", tc.expectedToken, exchanged.Token)
+			}
+			if exchanged.Expiry.IsZero() == tc.expectExpiry {
+				t.Errorf("want an expiry %v, got %v", tc.expectExpiry, exchanged.Expiry)
+			}
+			expectedForm := map[string]string{
+				"grant_type":           tokenExchangeGrantType,
+				"subject_token":        "gangway-id-token",
+				"subject_token_type":   tokenTypeIDToken,
+				"requested_token_type": requestedTokenType(tc.tokenType),
+				"audience":             "cluster",
+			}
+			for key, value := range expectedForm {
+				if got := strings.Join(form[key], ","); got != value {
+					t.Errorf("wrong %s: want %s, got %s", key, value, got)
+				}
+			}
+			if user != "gangway" || password != oauth2Cfg.ClientSecret {
+				t.Errorf("expected client authentication, got %s:%s", user, password)
+			}
+		})
+	}
+}
+
+func TestExchangeTokenUnreadableResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		fmt.Fprint(w, "<html>bad gateway</html>")
+	}))
+	defer server.Close()
+
+	cfg = &config.Config{TokenExchangeAudience: "cluster", TokenExchangeTokenType: config.TokenTypeAccessToken}
+	testInit()
+	oauth2Cfg.Endpoint.TokenURL = server.URL
+
+	_, err := exchangeToken(context.Background(), server.Client(), "gangway-id-token")
+	if err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("expected an error with the response status, got %v", err)
+	}
+}
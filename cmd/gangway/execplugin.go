@@ -0,0 +1,61 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/base64"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api/v1"
+)
+
+// kubelogin (https://github.com/int128/kubelogin) is run as a kubectl plugin to
+// obtain tokens for the public client, using PKCE instead of a client secret
+const (
+	execPluginCommand     = "kubectl"
+	execPluginAPIVersion  = "client.authentication.k8s.io/v1beta1"
+	execPluginInstallHint = "Install kubelogin to log in: https://github.com/int128/kubelogin#setup"
+)
+
+// execPluginArgs returns the arguments for kubelogin to log in with the given
+// client at the issuer, requesting the same scopes as gangway
+func execPluginArgs(issuerURL, clientID string, trustedCA []byte, scopes []string) []string {
+	args := []string{
+		"oidc-login",
+		"get-token",
+		"--oidc-issuer-url=" + issuerURL,
+		"--oidc-client-id=" + clientID,
+		"--oidc-use-pkce",
+	}
+	for _, scope := range scopes {
+		if scope != "openid" {
+			args = append(args, "--oidc-extra-scope="+scope)
+		}
+	}
+	if len(trustedCA) > 0 {
+		args = append(args, "--certificate-authority-data="+base64.StdEncoding.EncodeToString(trustedCA))
+	}
+	return args
+}
+
+// execPluginConfig returns the exec credential plugin configuration for the given arguments
+func execPluginConfig(args []string) *clientcmdapi.ExecConfig {
+	return &clientcmdapi.ExecConfig{
+		APIVersion:      execPluginAPIVersion,
+		Command:         execPluginCommand,
+		Args:            args,
+		InstallHint:     execPluginInstallHint,
+		InteractiveMode: clientcmdapi.IfAvailableExecInteractiveMode,
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExecPluginArgs(t *testing.T) {
+	args := execPluginArgs("https://idp.example.com", "kubectl", nil, []string{"openid", "email", "offline_access"})
+	want := []string{
+		"oidc-login",
+		"get-token",
+		"--oidc-issuer-url=https://idp.example.com",
+		"--oidc-client-id=kubectl",
+		"--oidc-use-pkce",
+		"--oidc-extra-scope=email",
+		"--oidc-extra-scope=offline_access",
+	}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("wrong arguments: want %v, got %v", want, args)
+	}
+
+	args = execPluginArgs("https://idp.example.com", "kubectl", []byte("ca"), nil)
+	if last := args[len(args)-1]; last != "--certificate-authority-data=Y2E=" {
+		t.Errorf("expected the trusted CA as last argument, got %s", last)
+	}
+}
+
+func TestKubeConfigAuthInfo(t *testing.T) {
+	info := &userInfo{
+		ClientID:     "kubectl",
+		IDToken:      "id",
+		RefreshToken: "refresh",
+		IssuerURL:    "https://idp.example.com",
+	}
+
+	authInfo := kubeConfigAuthInfo(info)
+	if authInfo.AuthProvider == nil || authInfo.Exec != nil {
+		t.Fatalf("expected the oidc auth provider, got %+v", authInfo)
+	}
+	if _, ok := authInfo.AuthProvider.Config["client-secret"]; ok {
+		t.Errorf("expected no client-secret for a public client")
+	}
+
+	info.ClientSecret = "secret"
+	authInfo = kubeConfigAuthInfo(info)
+	if authInfo.AuthProvider.Config["client-secret"] != "secret" {
+		t.Errorf("expected the client-secret, got %v", authInfo.AuthProvider.Config)
+	}
+
+	info.ExecArgs = execPluginArgs(info.IssuerURL, info.ClientID, nil, nil)
+	authInfo = kubeConfigAuthInfo(info)
+	if authInfo.Exec == nil || authInfo.AuthProvider != nil {
+		t.Fatalf("expected the exec plugin, got %+v", authInfo)
+	}
+	if authInfo.Exec.Command != execPluginCommand || !reflect.DeepEqual(authInfo.Exec.Args, info.ExecArgs) {
+		t.Errorf("wrong exec plugin: %+v", authInfo.Exec)
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+
+	log "github.com/sirupsen/logrus"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// kubeConfigFormat describes a way of delivering a kubeconfig
+type kubeConfigFormat struct {
+	// Name is the value of the format query parameter
+	Name        string
+	Extension   string
+	ContentType string
+	// MediaTypes are matched against the Accept header
+	MediaTypes []string
+	render     func(kcfg clientcmdapi.Config, filename string) ([]byte, error)
+}
+
+var (
+	yamlFormat = &kubeConfigFormat{
+		Name:        "yaml",
+		Extension:   "yaml",
+		ContentType: "application/yaml",
+		MediaTypes:  []string{"application/yaml", "application/x-yaml", "text/yaml"},
+		render:      renderYAML,
+	}
+	jsonFormat = &kubeConfigFormat{
+		Name:        "json",
+		Extension:   "json",
+		ContentType: "application/json",
+		MediaTypes:  []string{"application/json"},
+		render:      renderJSON,
+	}
+	shellFormat = &kubeConfigFormat{
+		Name:        "sh",
+		Extension:   "sh",
+		ContentType: "text/x-shellscript; charset=utf-8",
+		MediaTypes:  []string{"text/x-shellscript", "application/x-sh"},
+		render:      renderShellScript,
+	}
+	powershellFormat = &kubeConfigFormat{
+		Name:        "ps1",
+		Extension:   "ps1",
+		ContentType: "text/plain; charset=utf-8",
+		MediaTypes:  []string{"application/x-powershell"},
+		render:      renderPowerShellScript,
+	}
+
+	bootstrapShellFormat = &kubeConfigFormat{
+		Name:        "bootstrap-sh",
+		Extension:   "sh",
+		ContentType: "text/x-shellscript; charset=utf-8",
+		render:      renderBootstrapScript("bootstrap-sh.tmpl"),
+	}
+	bootstrapPowershellFormat = &kubeConfigFormat{
+		Name:        "bootstrap-ps1",
+		Extension:   "ps1",
+		ContentType: "text/plain; charset=utf-8",
+		render:      renderBootstrapScript("bootstrap-ps1.tmpl"),
+	}
+
+	// kubeConfigFormats lists the formats in order of preference, the first is the default
+	kubeConfigFormats = []*kubeConfigFormat{
+		yamlFormat, jsonFormat, shellFormat, powershellFormat, bootstrapShellFormat, bootstrapPowershellFormat,
+	}
+
+	// formatAliases maps alternative names for the format query parameter
+	formatAliases = map[string]string{
+		"yml":        "yaml",
+		"bash":       "sh",
+		"powershell": "ps1",
+	}
+)
+
+// unsafeFilenameChars matches characters that are replaced in download filenames
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// negotiateFormat picks the kubeconfig format from the format query parameter, or
+// else from the Accept header. It falls back to YAML when nothing matches.
+func negotiateFormat(r *http.Request) (*kubeConfigFormat, error) {
+	if name := strings.ToLower(r.URL.Query().Get("format")); name != "" {
+		if alias, ok := formatAliases[name]; ok {
+			name = alias
+		}
+		for _, f := range kubeConfigFormats {
+			if f.Name == name {
+				return f, nil
+			}
+		}
+
+		names := make([]string, 0, len(kubeConfigFormats))
+		for _, f := range kubeConfigFormats {
+			names = append(names, f.Name)
+		}
+		return nil, fmt.Errorf("unsupported format %q, use one of: %s", name, strings.Join(names, ", "))
+	}
+
+	for _, mediaType := range acceptedMediaTypes(r.Header.Get("Accept")) {
+		for _, f := range kubeConfigFormats {
+			for _, mt := range f.MediaTypes {
+				if mt == mediaType {
+					return f, nil
+				}
+			}
+		}
+	}
+	return yamlFormat, nil
+}
+
+// acceptedMediaTypes returns the media types of an Accept header, most preferred first
+func acceptedMediaTypes(accept string) []string {
+	type acceptEntry struct {
+		mediaType string
+		q         float64
+	}
+
+	var entries []acceptEntry
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			entries = append(entries, acceptEntry{mediaType, q})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].q > entries[j].q })
+
+	mediaTypes := make([]string, 0, len(entries))
+	for _, e := range entries {
+		mediaTypes = append(mediaTypes, e.mediaType)
+	}
+	return mediaTypes
+}
+
+// kubeConfigFilename returns the download filename for a cluster, e.g. kubeconfig-prod-eu.yaml
+func kubeConfigFilename(clusterName string, f *kubeConfigFormat) string {
+	name := strings.Trim(unsafeFilenameChars.ReplaceAllString(clusterName, "-"), "-.")
+	if name == "" {
+		return "kubeconfig." + f.Extension
+	}
+	return fmt.Sprintf("kubeconfig-%s.%s", name, f.Extension)
+}
+
+// writeKubeConfig renders the kubeconfig for info in the given format
+func writeKubeConfig(w http.ResponseWriter, info *userInfo, f *kubeConfigFormat) {
+	filename := kubeConfigFilename(info.ClusterName, f)
+	d, err := f.render(generateKubeConfig(info), filename)
+	if err != nil {
+		log.Errorf("Error creating kubeconfig - %s", err.Error())
+		http.Error(w, "Error creating kubeconfig", http.StatusInternalServerError)
+		return
+	}
+
+	// tell the browser the returned content should be downloaded
+	w.Header().Set("Content-Type", f.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	_, err = w.Write(d)
+	if err != nil {
+		log.Errorf("Failed to write kubeconfig: %v", err)
+	}
+}
+
+func renderYAML(kcfg clientcmdapi.Config, _ string) ([]byte, error) {
+	return yaml.Marshal(kcfg)
+}
+
+func renderJSON(kcfg clientcmdapi.Config, _ string) ([]byte, error) {
+	return json.MarshalIndent(kcfg, "", "  ")
+}
+
+// renderShellScript returns a single line to be used with eval. It writes the
+// kubeconfig to a temporary file and exports KUBECONFIG pointing at it.
+func renderShellScript(kcfg clientcmdapi.Config, filename string) ([]byte, error) {
+	d, err := yaml.Marshal(kcfg)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := strings.TrimSuffix(filename, ".sh")
+	script := fmt.Sprintf(`KUBECONFIG="$(mktemp "${TMPDIR:-/tmp}/%s.XXXXXX")" && `+
+		`echo '%s' | base64 --decode > "$KUBECONFIG" && export KUBECONFIG`+"\n",
+		prefix, base64.StdEncoding.EncodeToString(d))
+	return []byte(script), nil
+}
+
+// renderPowerShellScript is the PowerShell equivalent of renderShellScript, to be
+// used with Invoke-Expression.
+func renderPowerShellScript(kcfg clientcmdapi.Config, _ string) ([]byte, error) {
+	d, err := yaml.Marshal(kcfg)
+	if err != nil {
+		return nil, err
+	}
+
+	script := fmt.Sprintf(`$env:KUBECONFIG = (New-TemporaryFile).FullName; `+
+		`[IO.File]::WriteAllBytes($env:KUBECONFIG, [Convert]::FromBase64String('%s'))`+"\r\n",
+		base64.StdEncoding.EncodeToString(d))
+	return []byte(script), nil
+}
+
+// renderBootstrapScript returns a renderer for a script that runs the kubectl config
+// commands adding the kubeconfig entries to the current kubeconfig of the user
+func renderBootstrapScript(tmplFile string) func(kcfg clientcmdapi.Config, _ string) ([]byte, error) {
+	return func(kcfg clientcmdapi.Config, _ string) ([]byte, error) {
+		templateData, _, err := readTemplate(tmplFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tmpl, err := texttemplate.New(tmplFile).Funcs(texttemplate.FuncMap(genericMap)).Parse(string(templateData))
+		if err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		if err = tmpl.Execute(&buf, kcfg); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+}
@@ -0,0 +1,276 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/jcrood/gangway/internal/config"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := map[string]struct {
+		query       string
+		accept      string
+		expected    *kubeConfigFormat
+		expectError bool
+	}{
+		"default":              {expected: yamlFormat},
+		"query parameter":      {query: "format=json", expected: jsonFormat},
+		"query alias":          {query: "format=powershell", expected: powershellFormat},
+		"query wins":           {query: "format=sh", accept: "application/json", expected: shellFormat},
+		"unknown query":        {query: "format=toml", expectError: true},
+		"accept header":        {accept: "application/json", expected: jsonFormat},
+		"accept with quality":  {accept: "application/yaml;q=0.5, application/json", expected: jsonFormat},
+		"accept excluded type": {accept: "application/json;q=0, text/x-shellscript", expected: shellFormat},
+		"accept browser":       {accept: "text/html,application/xhtml+xml,*/*;q=0.8", expected: yamlFormat},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/kubeconf?"+tc.query, nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+
+			f, err := negotiateFormat(req)
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("expected an error, got format %q", f.Name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if f != tc.expected {
+				t.Errorf("expected format %q, got %q", tc.expected.Name, f.Name)
+			}
+		})
+	}
+}
+
+func TestKubeConfigFilename(t *testing.T) {
+	tests := map[string]struct {
+		clusterName string
+		format      *kubeConfigFormat
+		want        string
+	}{
+		"plain":       {clusterName: "prod-eu", format: yamlFormat, want: "kubeconfig-prod-eu.yaml"},
+		"json":        {clusterName: "prod-eu", format: jsonFormat, want: "kubeconfig-prod-eu.json"},
+		"unsafe":      {clusterName: "prod eu/\"1\"", format: shellFormat, want: "kubeconfig-prod-eu-1.sh"},
+		"no name":     {clusterName: "", format: powershellFormat, want: "kubeconfig.ps1"},
+		"only unsafe": {clusterName: "../", format: yamlFormat, want: "kubeconfig.yaml"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := kubeConfigFilename(tc.clusterName, tc.format); got != tc.want {
+				t.Errorf("kubeConfigFilename(): want %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestWriteKubeConfig(t *testing.T) {
+	info := &userInfo{
+		ClusterName:    "prod-eu",
+		KubeCfgCluster: "prod-eu",
+		KubeCfgContext: "prod-eu",
+		KubeCfgUser:    "alice@prod-eu",
+		APIServerURL:   "https://kubernetes",
+		IDToken:        "id-token",
+		Contexts:       []kubeContext{{Name: "prod-eu"}},
+	}
+	embedded := regexp.MustCompile(`'([A-Za-z0-9+/=]+)'`)
+
+	tests := map[string]struct {
+		format *kubeConfigFormat
+		decode func(t *testing.T, body []byte) []byte
+	}{
+		"yaml": {
+			format: yamlFormat,
+			decode: func(t *testing.T, body []byte) []byte { return body },
+		},
+		"json": {
+			format: jsonFormat,
+			decode: func(t *testing.T, body []byte) []byte {
+				if !json.Valid(body) {
+					t.Fatalf("response is not valid JSON: %s", body)
+				}
+				return body
+			},
+		},
+		"shell": {
+			format: shellFormat,
+			decode: func(t *testing.T, body []byte) []byte {
+				m := embedded.FindSubmatch(body)
+				if m == nil {
+					t.Fatalf("no embedded kubeconfig in script: %s", body)
+				}
+				d, err := base64.StdEncoding.DecodeString(string(m[1]))
+				if err != nil {
+					t.Fatalf("error decoding embedded kubeconfig: %v", err)
+				}
+				return d
+			},
+		},
+		"powershell": {
+			format: powershellFormat,
+			decode: func(t *testing.T, body []byte) []byte {
+				m := embedded.FindSubmatch(body)
+				if m == nil {
+					t.Fatalf("no embedded kubeconfig in script: %s", body)
+				}
+				d, err := base64.StdEncoding.DecodeString(string(m[1]))
+				if err != nil {
+					t.Fatalf("error decoding embedded kubeconfig: %v", err)
+				}
+				return d
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			rsp := httptest.NewRecorder()
+			writeKubeConfig(rsp, info, tc.format)
+
+			if ct := rsp.Header().Get("Content-Type"); ct != tc.format.ContentType {
+				t.Errorf("expected content type %q, got %q", tc.format.ContentType, ct)
+			}
+			expectedDisposition := `attachment; filename=kubeconfig-prod-eu.` + tc.format.Extension
+			if cd := rsp.Header().Get("Content-Disposition"); cd != expectedDisposition {
+				t.Errorf("expected content disposition %q, got %q", expectedDisposition, cd)
+			}
+
+			kcfg := &clientcmdapi.Config{}
+			if err := yaml.Unmarshal(tc.decode(t, rsp.Body.Bytes()), kcfg); err != nil {
+				t.Fatalf("error unmarshaling kubeconfig: %v", err)
+			}
+			if len(kcfg.AuthInfos) != 1 || kcfg.AuthInfos[0].AuthInfo.AuthProvider.Config["id-token"] != "id-token" {
+				t.Errorf("unexpected users in kubeconfig: %v", kcfg.AuthInfos)
+			}
+		})
+	}
+}
+
+func TestBootstrapScripts(t *testing.T) {
+	cfg = &config.Config{}
+	info := &userInfo{
+		ClusterName:    "prod-eu",
+		KubeCfgCluster: "prod-eu",
+		KubeCfgContext: "prod-eu",
+		KubeCfgUser:    "o'neil@prod-eu",
+		APIServerURL:   "https://kubernetes",
+		ClusterCA:      "dummy cluster CA",
+		IDToken:        "id-token",
+		ClientID:       "someClientID",
+		Contexts:       []kubeContext{{Name: "prod-eu", Namespace: "team-a"}},
+	}
+	certInfo := *info
+	certInfo.IDToken = ""
+	certInfo.ClientCertificate = "dummy certificate"
+	certInfo.ClientKey = "dummy key"
+	tokenInfo := *info
+	tokenInfo.IDToken = ""
+	tokenInfo.BearerToken = "service-account-token"
+
+	tests := map[string]struct {
+		info     *userInfo
+		format   *kubeConfigFormat
+		expected []string
+	}{
+		"shell": {
+			format: bootstrapShellFormat,
+			expected: []string{
+				`printf '%s\n' 'dummy cluster CA' > "$tmp_dir/ca.crt"`,
+				`kubectl config set-cluster 'prod-eu' --server='https://kubernetes' --certificate-authority="$tmp_dir/ca.crt" --embed-certs`,
+				`kubectl config set-credentials 'o'\''neil@prod-eu' --auth-provider='oidc' \`,
+				`    --auth-provider-arg='id-token=id-token'`,
+				`kubectl config set-context 'prod-eu' --cluster='prod-eu' --user='o'\''neil@prod-eu' --namespace='team-a'`,
+				`kubectl config use-context 'prod-eu'`,
+			},
+		},
+		"powershell": {
+			format: bootstrapPowershellFormat,
+			expected: []string{
+				`Set-Content -Path $caFile -Value 'dummy cluster CA'`,
+				"kubectl config set-credentials 'o''neil@prod-eu' --auth-provider='oidc' `",
+				"    --auth-provider-arg='client-id=someClientID' `",
+				`kubectl config use-context 'prod-eu'`,
+			},
+		},
+		"shell with client certificate": {
+			info:   &certInfo,
+			format: bootstrapShellFormat,
+			expected: []string{
+				`printf '%s\n' 'dummy certificate' > "$tmp_dir/client.crt"`,
+				`printf '%s\n' 'dummy key' > "$tmp_dir/client.key"`,
+				`kubectl config set-credentials 'o'\''neil@prod-eu' --client-certificate="$tmp_dir/client.crt" --client-key="$tmp_dir/client.key" --embed-certs`,
+			},
+		},
+		"powershell with client certificate": {
+			info:   &certInfo,
+			format: bootstrapPowershellFormat,
+			expected: []string{
+				`    Set-Content -Path $certFile -Value 'dummy certificate'`,
+				`    Set-Content -Path $keyFile -Value 'dummy key'`,
+				`    kubectl config set-credentials 'o''neil@prod-eu' --client-certificate="$certFile" --client-key="$keyFile" --embed-certs`,
+			},
+		},
+		"shell with bearer token": {
+			info:     &tokenInfo,
+			format:   bootstrapShellFormat,
+			expected: []string{`kubectl config set-credentials 'o'\''neil@prod-eu' --token='service-account-token'`},
+		},
+		"powershell with bearer token": {
+			info:     &tokenInfo,
+			format:   bootstrapPowershellFormat,
+			expected: []string{`kubectl config set-credentials 'o''neil@prod-eu' --token='service-account-token'`},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if tc.info == nil {
+				tc.info = info
+			}
+			rsp := httptest.NewRecorder()
+			writeKubeConfig(rsp, tc.info, tc.format)
+
+			if rsp.Code != http.StatusOK {
+				t.Fatalf("unexpected status code %d: %s", rsp.Code, rsp.Body.String())
+			}
+			body := rsp.Body.String()
+			for _, line := range tc.expected {
+				if !strings.Contains(body, line+"\n") {
+					t.Errorf("expected script to contain line %q, got:\n%s", line, body)
+				}
+			}
+			if strings.Contains(body, "client-secret") {
+				t.Errorf("script contains an empty client secret:\n%s", body)
+			}
+		})
+	}
+}
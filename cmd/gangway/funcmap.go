@@ -15,11 +15,35 @@ package main
 
 import (
 	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"html/template"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
 )
 
+// genericMap is the function library available to all HTML and script templates. Functions
+// taking a value to operate on accept it as their last argument, so they can be used in pipelines.
 var genericMap = map[string]interface{}{
-	"base64enc": base64encode,
+	"base64enc":  base64encode,
+	"shquote":    shellQuote,
+	"psquote":    powershellQuote,
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"replace":    replace,
+	"trimPrefix": trimPrefix,
+	"toYaml":     toYAML,
+	"toJson":     toJSON,
+	"default":    defaultValue,
+	"join":       join,
+	"date":       formatDate,
+	"hasGroup":   hasGroup,
+	"hasClaim":   hasClaim,
+	"urlPath":    urlPath,
 }
 
 func FuncMap() template.FuncMap {
@@ -29,3 +53,120 @@ func FuncMap() template.FuncMap {
 func base64encode(v string) string {
 	return base64.StdEncoding.EncodeToString([]byte(v))
 }
+
+// shellQuote quotes a value as a single argument for POSIX shells
+func shellQuote(v string) string {
+	return "'" + strings.ReplaceAll(v, "'", `'\''`) + "'"
+}
+
+// powershellQuotes escapes the characters PowerShell accepts as single quotes
+var powershellQuotes = strings.NewReplacer(
+	"'", "''",
+	"\u2018", "\u2018\u2018",
+	"\u2019", "\u2019\u2019",
+	"\u201a", "\u201a\u201a",
+	"\u201b", "\u201b\u201b",
+)
+
+// powershellQuote quotes a value as a verbatim PowerShell string
+func powershellQuote(v string) string {
+	return "'" + powershellQuotes.Replace(v) + "'"
+}
+
+// replace replaces all occurrences of old in s by new
+func replace(old, new, s string) string {
+	return strings.ReplaceAll(s, old, new)
+}
+
+// trimPrefix returns s without the leading prefix
+func trimPrefix(prefix, s string) string {
+	return strings.TrimPrefix(s, prefix)
+}
+
+// toYAML renders a value as YAML, or as "" if it cannot be marshalled
+func toYAML(v interface{}) string {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(string(data), "\n")
+}
+
+// toJSON renders a value as JSON, or as "" if it cannot be marshalled
+func toJSON(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// defaultValue returns v, or def if v is missing or the zero value of its type
+func defaultValue(def, v interface{}) interface{} {
+	if v == nil {
+		return def
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Array:
+		if rv.Len() == 0 {
+			return def
+		}
+	default:
+		if rv.IsZero() {
+			return def
+		}
+	}
+	return v
+}
+
+// join joins the elements of a list, such as the groups of the user, with sep
+func join(sep string, list interface{}) string {
+	return strings.Join(toStrings(list), sep)
+}
+
+// toStrings converts a list of strings or a list taken from the claims to a []string
+func toStrings(list interface{}) []string {
+	switch l := list.(type) {
+	case []string:
+		return l
+	case []interface{}:
+		s := make([]string, 0, len(l))
+		for _, v := range l {
+			s = append(s, fmt.Sprint(v))
+		}
+		return s
+	case string:
+		return []string{l}
+	}
+	return nil
+}
+
+// formatDate formats a time with a Go layout, e.g. "2006-01-02 15:04 MST". Zero times format as "".
+func formatDate(layout string, t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(layout)
+}
+
+// hasGroup reports whether group is in the list of groups
+func hasGroup(group string, groups interface{}) bool {
+	for _, g := range toStrings(groups) {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// hasClaim reports whether the claims contain name
+func hasClaim(name string, claims map[string]interface{}) bool {
+	_, ok := claims[name]
+	return ok
+}
+
+// urlPath returns the path of a gangway page, prefixed with the configured httpPath
+func urlPath(elem ...string) string {
+	return path.Join(append([]string{"/", cfg.HTTPPath}, elem...)...)
+}
@@ -0,0 +1,131 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"testing"
+	"time"
+
+	"github.com/jcrood/gangway/internal/config"
+)
+
+func TestShellQuote(t *testing.T) {
+	tests := map[string]string{
+		"plain":        "'plain'",
+		"":             "''",
+		"o'neil":       `'o'\''neil'`,
+		"$(rm -rf /)":  "'$(rm -rf /)'",
+		"line\nbreak":  "'line\nbreak'",
+		"back\\slash":  `'back\slash'`,
+		"\"double\"":   `'"double"'`,
+		"two '' ones":  `'two '\'''\'' ones'`,
+		"`backticks`":  "'`backticks`'",
+		"semi;colon&&": "'semi;colon&&'",
+	}
+
+	for in, want := range tests {
+		if got := shellQuote(in); got != want {
+			t.Errorf("shellQuote(%q): want %s, got %s", in, want, got)
+		}
+	}
+}
+
+func TestPowershellQuote(t *testing.T) {
+	tests := map[string]string{
+		"plain":         "'plain'",
+		"o'neil":        "'o''neil'",
+		"o’neil":        "'o’’neil'",
+		"$env:HOME":     "'$env:HOME'",
+		"`backticks`":   "'`backticks`'",
+		"\"double\"":    "'\"double\"'",
+		"$(Get-Secret)": "'$(Get-Secret)'",
+	}
+
+	for in, want := range tests {
+		if got := powershellQuote(in); got != want {
+			t.Errorf("powershellQuote(%q): want %s, got %s", in, want, got)
+		}
+	}
+}
+
+func TestFuncMap(t *testing.T) {
+	cfg = &config.Config{HTTPPath: "/gangway"}
+	data := &userInfo{
+		Username: "Alice.Smith",
+		Groups:   []string{"dev", "ops"},
+		Claims: map[string]interface{}{
+			"email":  "alice@example.com",
+			"groups": []interface{}{"dev", "ops"},
+			"tenant": map[string]interface{}{"id": "t-1"},
+		},
+		Expiry: time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+
+	tests := map[string]struct {
+		tmpl string
+		want string
+	}{
+		"lower":            {tmpl: `{{ .Username | lower }}`, want: "alice.smith"},
+		"upper":            {tmpl: `{{ upper .Username }}`, want: "ALICE.SMITH"},
+		"replace":          {tmpl: `{{ .Username | replace "." "-" }}`, want: "Alice-Smith"},
+		"trimPrefix":       {tmpl: `{{ index .Claims "email" | trimPrefix "alice@" }}`, want: "example.com"},
+		"toYaml":           {tmpl: `{{ index .Claims "tenant" | toYaml }}`, want: "id: t-1"},
+		"toJson":           {tmpl: `{{ .Groups | toJson }}`, want: `[&#34;dev&#34;,&#34;ops&#34;]`},
+		"default missing":  {tmpl: `{{ index .Claims "team" | default "none" }}`, want: "none"},
+		"default empty":    {tmpl: `{{ .Namespace | default "default" }}`, want: "default"},
+		"default set":      {tmpl: `{{ .Username | default "nobody" }}`, want: "Alice.Smith"},
+		"join":             {tmpl: `{{ join ", " .Groups }}`, want: "dev, ops"},
+		"join claim":       {tmpl: `{{ index .Claims "groups" | join " " }}`, want: "dev ops"},
+		"date":             {tmpl: `{{ .Expiry | date "2006-01-02 15:04 MST" }}`, want: "2021-03-04 05:06 UTC"},
+		"date zero":        {tmpl: `{{ .AuthTime | date "2006-01-02" }}`, want: ""},
+		"hasGroup":         {tmpl: `{{ hasGroup "ops" .Groups }}`, want: "true"},
+		"hasGroup missing": {tmpl: `{{ hasGroup "admin" .Groups }}`, want: "false"},
+		"hasGroup claim":   {tmpl: `{{ index .Claims "groups" | hasGroup "dev" }}`, want: "true"},
+		"hasClaim":         {tmpl: `{{ hasClaim "email" .Claims }}`, want: "true"},
+		"hasClaim missing": {tmpl: `{{ hasClaim "phone_number" .Claims }}`, want: "false"},
+		"urlPath":          {tmpl: `{{ urlPath "kubeconf" }}`, want: "/gangway/kubeconf"},
+		"urlPath nested":   {tmpl: `{{ urlPath "kubeconf" "merge" }}`, want: "/gangway/kubeconf/merge"},
+		"urlPath root":     {tmpl: `{{ urlPath }}`, want: "/gangway"},
+		"urlPath in attr":  {tmpl: `<a href="{{ urlPath "rbac" }}">`, want: `<a href="/gangway/rbac">`},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			tmpl, err := template.New(name).Funcs(FuncMap()).Parse(tc.tmpl)
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, data); err != nil {
+				t.Fatalf("failed to execute: %v", err)
+			}
+			if got := buf.String(); got != tc.want {
+				t.Errorf("want %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestURLPathWithoutHTTPPath(t *testing.T) {
+	cfg = &config.Config{}
+	if got := urlPath("login"); got != "/login" {
+		t.Errorf("want /login, got %s", got)
+	}
+	if got := urlPath(); got != "/" {
+		t.Errorf("want /, got %s", got)
+	}
+}
@@ -15,39 +15,103 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"encoding/base64"
-	"fmt"
+	"errors"
 	htmltemplate "html/template"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
 	"path/filepath"
-	"strings"
+	"time"
 
+	"github.com/gorilla/sessions"
+	"github.com/jcrood/gangway/internal/config"
 	"github.com/jcrood/gangway/templates"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/oauth2"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api/v1"
-	"sigs.k8s.io/yaml"
 )
 
 // userInfo stores information about an authenticated user
 type userInfo struct {
-	ClusterName  string
-	Username     string
-	Claims       map[string]interface{}
-	KubeCfgUser  string
-	IDToken      string
-	RefreshToken string
-	ClientID     string
-	ClientSecret string
-	IssuerURL    string
-	APIServerURL string
-	ClusterCA    string
-	TrustedCA    string
-	HTTPPath     string
-	ShowClaims   bool
+	ClusterName    string
+	Username       string
+	Groups         []string
+	Claims         map[string]interface{}
+	KubeCfgUser    string
+	KubeCfgCluster string
+	KubeCfgContext string
+	IDToken        string
+	RefreshToken   string
+	ClientID       string
+	ClientSecret   string
+	IssuerURL      string
+	APIServerURL   string
+	ClusterCA      string
+	TrustedCA      string
+	HTTPPath       string
+	ShowClaims     bool
+	Namespace      string
+	Namespaces     []string
+	Contexts       []kubeContext
+
+	// Expiry, IssuedAt and AuthTime are taken from the ID token, AuthTime is zero if the IdP did not send it
+	Expiry          time.Time
+	IssuedAt        time.Time
+	AuthTime        time.Time
+	HasRefreshToken bool
+	// CredentialKind and CredentialExpiry describe the credentials put in the kubeconfig,
+	// CredentialExpiry is zero when these do not expire or the expiry is not known
+	CredentialKind   string    `json:"-"`
+	CredentialExpiry time.Time `json:"-"`
+
+	// ExecArgs are the arguments to the exec credential plugin, replacing the oidc auth provider when set
+	ExecArgs []string
+	// ClientCertificate and ClientKey are PEM encoded, replacing the tokens when set
+	ClientCertificate string
+	ClientKey         string
+	// BearerToken replaces the oidc auth provider when set
+	BearerToken string
+
+	// DownloadURL and BootstrapURL are one-time links to the kubeconfig, valid for DownloadLinkTTL
+	SnippetMode     string        `json:"-"`
+	DownloadURL     string        `json:"-"`
+	BootstrapURL    string        `json:"-"`
+	DownloadLinkTTL time.Duration `json:"-"`
+	RBACPreview     bool          `json:"-"`
+	// CSRFToken has to be sent along with forms posted back to gangway
+	CSRFToken string `json:"-"`
+
+	// StandardClaims and CustomClaims are the claims rendered as YAML for the claim dump
+	StandardClaims string `json:"-"`
+	CustomClaims   string `json:"-"`
+}
+
+// ExpiresIn returns the time left until the ID token expires
+func (info *userInfo) ExpiresIn() time.Duration {
+	return time.Until(info.Expiry).Round(time.Second)
+}
+
+// CredentialExpiresIn returns the time left until the credentials in the kubeconfig expire
+func (info *userInfo) CredentialExpiresIn() time.Duration {
+	return time.Until(info.CredentialExpiry).Round(time.Second)
+}
+
+// CredentialName describes the credentials in the kubeconfig to users
+func (info *userInfo) CredentialName() string {
+	switch info.CredentialKind {
+	case credentialKindExchanged:
+		return "token"
+	case credentialKindServiceAccount:
+		return "ServiceAccount token"
+	case credentialKindCertificate:
+		return "client certificate"
+	}
+	return "ID token"
 }
 
 // homeInfo is used to store dynamic properties on
@@ -56,36 +120,70 @@ type homeInfo struct {
 	HTTPPath    string
 }
 
-func serveTemplate(tmplFile string, data interface{}, w http.ResponseWriter) {
-	var (
-		templatePath string
-		templateData []byte
-		err          error
-	)
+// errorInfo describes an error response returned by the IdP on the callback
+type errorInfo struct {
+	ClusterName      string
+	HTTPPath         string
+	Error            string
+	ErrorDescription string
+	ErrorURI         string
+}
+
+func serveTemplate(tmplFile string, data interface{}, w http.ResponseWriter, r *http.Request) {
+	serveTemplateWithStatus(tmplFile, data, http.StatusOK, w, r)
+}
 
-	// Use custom templates if provided
+// readTemplate returns the contents of a template and the path it was read from
+func readTemplate(tmplFile string) ([]byte, string, error) {
+	// Use custom templates if provided, falling back to the built-in template
+	// for pages the custom set does not override
 	if cfg.CustomHTMLTemplatesDir != "" {
-		templatePath = filepath.Join(cfg.CustomHTMLTemplatesDir, tmplFile)
-		templateData, err = ioutil.ReadFile(templatePath)
-	} else {
-		templateData, err = templates.FS.ReadFile(tmplFile)
+		templatePath := filepath.Join(cfg.CustomHTMLTemplatesDir, tmplFile)
+		templateData, err := ioutil.ReadFile(templatePath)
+		if !os.IsNotExist(err) {
+			return templateData, templatePath, err
+		}
 	}
 
+	templateData, err := templates.FS.ReadFile(tmplFile)
+	return templateData, "", err
+}
+
+func serveTemplateWithStatus(tmplFile string, data interface{}, status int, w http.ResponseWriter, r *http.Request) {
+	templateData, templatePath, err := readTemplate(tmplFile)
 	if err != nil {
 		log.Errorf("Failed to find template asset: %s at path: %s", tmplFile, templatePath)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	tmpl := htmltemplate.New(tmplFile).Funcs(FuncMap())
+	// cspNonce is bound per request, for the scripts allowed by the Content-Security-Policy
+	nonce := requestCSPNonce(r)
+	tmpl := htmltemplate.New(tmplFile).Funcs(FuncMap()).Funcs(htmltemplate.FuncMap{
+		"cspNonce": func() string { return nonce },
+	})
 	tmpl, err = tmpl.Parse(string(templateData))
 	if err != nil {
 		log.Errorf("Failed to parse template: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	err = tmpl.ExecuteTemplate(w, tmplFile, data)
+
+	// render into a buffer first so a failing template does not leave a
+	// half-written page behind a success status
+	var buf bytes.Buffer
+	err = tmpl.ExecuteTemplate(&buf, tmplFile, data)
 	if err != nil {
 		log.Errorf("Failed to render template %s: %s", tmplFile, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, err = buf.WriteTo(w)
+	if err != nil {
+		log.Errorf("Failed to write template %s: %s", tmplFile, err)
 	}
 }
 
@@ -94,57 +192,77 @@ func generateKubeConfig(cfg *userInfo) clientcmdapi.Config {
 	kcfg := clientcmdapi.Config{
 		Kind:           "Config",
 		APIVersion:     "v1",
-		CurrentContext: cfg.ClusterName,
+		CurrentContext: cfg.KubeCfgContext,
 		Clusters: []clientcmdapi.NamedCluster{
 			{
-				Name: cfg.ClusterName,
+				Name: cfg.KubeCfgCluster,
 				Cluster: clientcmdapi.Cluster{
 					Server:                   cfg.APIServerURL,
 					CertificateAuthorityData: []byte(cfg.ClusterCA),
 				},
 			},
 		},
-		Contexts: []clientcmdapi.NamedContext{
-			{
-				Name: cfg.ClusterName,
-				Context: clientcmdapi.Context{
-					Cluster:  cfg.ClusterName,
-					AuthInfo: cfg.KubeCfgUser,
-				},
-			},
-		},
 		AuthInfos: []clientcmdapi.NamedAuthInfo{
 			{
-				Name: cfg.KubeCfgUser,
-				AuthInfo: clientcmdapi.AuthInfo{
-					AuthProvider: &clientcmdapi.AuthProviderConfig{
-						Name: "oidc",
-						Config: map[string]string{
-							"client-id":                      cfg.ClientID,
-							"client-secret":                  cfg.ClientSecret,
-							"id-token":                       cfg.IDToken,
-							"idp-issuer-url":                 cfg.IssuerURL,
-							"idp-certificate-authority-data": base64.StdEncoding.EncodeToString([]byte(cfg.TrustedCA)),
-							"refresh-token":                  cfg.RefreshToken,
-						},
-					},
-				},
+				Name:     cfg.KubeCfgUser,
+				AuthInfo: kubeConfigAuthInfo(cfg),
 			},
 		},
 	}
+
+	for _, c := range cfg.Contexts {
+		kcfg.Contexts = append(kcfg.Contexts, clientcmdapi.NamedContext{
+			Name: c.Name,
+			Context: clientcmdapi.Context{
+				Cluster:   cfg.KubeCfgCluster,
+				AuthInfo:  cfg.KubeCfgUser,
+				Namespace: c.Namespace,
+			},
+		})
+	}
 	return kcfg
 }
 
+// kubeConfigAuthInfo returns the credentials of the user, leaving out the client secret if there is none
+func kubeConfigAuthInfo(cfg *userInfo) clientcmdapi.AuthInfo {
+	if cfg.BearerToken != "" {
+		return clientcmdapi.AuthInfo{Token: cfg.BearerToken}
+	}
+	if cfg.ClientCertificate != "" {
+		return clientcmdapi.AuthInfo{
+			ClientCertificateData: []byte(cfg.ClientCertificate),
+			ClientKeyData:         []byte(cfg.ClientKey),
+		}
+	}
+	if len(cfg.ExecArgs) > 0 {
+		return clientcmdapi.AuthInfo{Exec: execPluginConfig(cfg.ExecArgs)}
+	}
+
+	providerConfig := map[string]string{
+		"client-id":                      cfg.ClientID,
+		"id-token":                       cfg.IDToken,
+		"idp-issuer-url":                 cfg.IssuerURL,
+		"idp-certificate-authority-data": base64.StdEncoding.EncodeToString([]byte(cfg.TrustedCA)),
+		"refresh-token":                  cfg.RefreshToken,
+	}
+	if cfg.ClientSecret != "" {
+		providerConfig["client-secret"] = cfg.ClientSecret
+	}
+	return clientcmdapi.AuthInfo{
+		AuthProvider: &clientcmdapi.AuthProviderConfig{
+			Name:   "oidc",
+			Config: providerConfig,
+		},
+	}
+}
+
 func loginRequired(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		session, err := gangwayUserSession.Session.Get(r, "gangway_id_token")
-		if err != nil {
-			http.Redirect(w, r, cfg.GetRootPathPrefix(), http.StatusTemporaryRedirect)
-			return
-		}
-
-		if session.Values["id_token"] == nil {
-			http.Redirect(w, r, cfg.GetRootPathPrefix(), http.StatusTemporaryRedirect)
+		if err != nil || session.Values["id_token"] == nil {
+			// bring users back to the page they asked for once they logged in
+			rememberReturnPath(w, r)
+			http.Redirect(w, r, externalURL(r, ""), http.StatusTemporaryRedirect)
 			return
 		}
 
@@ -152,13 +270,13 @@ func loginRequired(next http.Handler) http.Handler {
 	})
 }
 
-func homeHandler(w http.ResponseWriter, _ *http.Request) {
+func homeHandler(w http.ResponseWriter, r *http.Request) {
 	data := &homeInfo{
 		ClusterName: cfg.ClusterName,
 		HTTPPath:    cfg.HTTPPath,
 	}
 
-	serveTemplate("home.tmpl", data, w)
+	serveTemplate("home.tmpl", data, w, r)
 }
 
 func loginHandler(w http.ResponseWriter, r *http.Request) {
@@ -172,14 +290,16 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	state := base64.URLEncoding.EncodeToString(b)
 
+	// a session that no longer decodes, e.g. after its key was retired, is replaced by a new one
 	session, err := gangwayUserSession.Session.Get(r, "gangway")
 	if err != nil {
-		log.Errorf("Got an error in login: %s", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		log.Warnf("Starting a new session at login: %s", err)
 	}
 
+	// the callback has to send the identity provider the same redirect URI as the login
+	redirect := redirectURI(r)
 	session.Values["state"] = state
+	session.Values["redirect_uri"] = redirect
 	err = session.Save(r, w)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -187,16 +307,49 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	audience := oauth2.SetAuthURLParam("audience", cfg.Audience)
-	url := oauth2Cfg.AuthCodeURL(state, audience)
+	url := oauth2Cfg.AuthCodeURL(state, audience, oauth2.SetAuthURLParam("redirect_uri", redirect))
 
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
 
+// sessionNames are the sessions kept for a logged in user
+var sessionNames = []string{"gangway", "gangway_id_token", "gangway_refresh_token", "gangway_credential"}
+
+// sessionOptions returns the attributes of the session cookies
+func sessionOptions() *sessions.Options {
+	sameSite := http.SameSiteLaxMode
+	switch cfg.CookieSameSite {
+	case config.CookieSameSiteStrict:
+		sameSite = http.SameSiteStrictMode
+	case config.CookieSameSiteNone:
+		sameSite = http.SameSiteNoneMode
+	}
+	return &sessions.Options{
+		Path:     cfg.SessionCookiePath(),
+		Domain:   cfg.CookieDomain,
+		MaxAge:   int(cfg.CookieMaxAge.Seconds()),
+		Secure:   cfg.SecureCookies(),
+		HttpOnly: true,
+		SameSite: sameSite,
+	}
+}
+
+// cleanupSessions removes all sessions of the user
+func cleanupSessions(w http.ResponseWriter, r *http.Request) {
+	for _, name := range sessionNames {
+		gangwayUserSession.Cleanup(w, r, name)
+	}
+}
+
 func logoutHandler(w http.ResponseWriter, r *http.Request) {
-	gangwayUserSession.Cleanup(w, r, "gangway")
-	gangwayUserSession.Cleanup(w, r, "gangway_id_token")
-	gangwayUserSession.Cleanup(w, r, "gangway_refresh_token")
-	http.Redirect(w, r, cfg.GetRootPathPrefix(), http.StatusTemporaryRedirect)
+	// logging out changes state, so it is a POST protected by csrfProtect
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	cleanupSessions(w, r)
+	http.Redirect(w, r, externalURL(r, ""), http.StatusSeeOther)
 }
 
 func callbackHandler(w http.ResponseWriter, r *http.Request) {
@@ -228,9 +381,23 @@ func callbackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the IdP redirects back with an error instead of a code when it refuses the login
+	if idpError := r.URL.Query().Get("error"); idpError != "" {
+		idpErrorHandler(w, r, idpError)
+		return
+	}
+
 	// use the access code to retrieve a token
 	code := r.URL.Query().Get("code")
-	oauth2Token, err := oauth2Cfg.Exchange(ctx, code)
+	if code == "" {
+		http.Error(w, "missing authorization code", http.StatusBadRequest)
+		return
+	}
+	redirect, ok := session.Values["redirect_uri"].(string)
+	if !ok {
+		redirect = oauth2Cfg.RedirectURL
+	}
+	oauth2Token, err := oauth2Cfg.Exchange(ctx, code, oauth2.SetAuthURLParam("redirect_uri", redirect))
 	// token, err := o2token.Exchange(ctx, code)
 	if err != nil {
 		log.Errorf("failed to exchange token: %v", err)
@@ -245,14 +412,55 @@ func callbackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = verifier.Verify(ctx, rawIDToken)
+	idToken, err := verifier.Verify(ctx, rawIDToken)
 	if err != nil {
 		log.Errorf("failed to verify token: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	sessionIDToken.Values["id_token"] = rawIDToken
-	sessionRefreshToken.Values["refresh_token"] = oauth2Token.RefreshToken
+	// the refresh token of the login is only handed out with the tokens of the login,
+	// there is no need to carry it around otherwise
+	sessionRefreshToken.Values["refresh_token"] = ""
+	if !usesCredentialSession() {
+		sessionRefreshToken.Values["refresh_token"] = oauth2Token.RefreshToken
+	}
+	// the state is only good for a single login
+	delete(session.Values, "state")
+	delete(session.Values, "redirect_uri")
+	returnTo := takeReturnPath(session.Values)
+
+	// obtain the credentials the cluster accepts, if these are not the tokens of the login
+	if usesCredentialSession() {
+		claims := make(map[string]interface{})
+		if err := idToken.Claims(&claims); err != nil {
+			log.Errorf("failed to unmarshal claims: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		values, err := issueCredential(ctx, rawIDToken, claims)
+		if errors.Is(err, errCredentialNotAllowed) {
+			log.Warnf("refused credentials for %v from %s: %v", claims[cfg.UsernameClaim], clientAddr(r), err)
+			http.Error(w, "You are not allowed to obtain credentials for this cluster", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			log.Errorf("failed to issue credentials: %v", err)
+			http.Error(w, "Could not obtain credentials for the cluster", http.StatusInternalServerError)
+			return
+		}
+		sessionCredential, err := gangwayUserSession.Session.Get(r, "gangway_credential")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sessionCredential.Values = values
+		err = sessionCredential.Save(r, w)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 
 	// save the session cookies
 	err = session.Save(r, w)
@@ -271,7 +479,37 @@ func callbackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	http.Redirect(w, r, fmt.Sprintf("%s/commandline", cfg.HTTPPath), http.StatusSeeOther)
+	http.Redirect(w, r, externalURL(r, returnTo), http.StatusSeeOther)
+}
+
+// idpErrorHandler renders the error returned by the IdP together with an option to retry
+func idpErrorHandler(w http.ResponseWriter, r *http.Request, idpError string) {
+	description := r.URL.Query().Get("error_description")
+	callbackErrors.Add(oauth2ErrorLabel(idpError), 1)
+	log.Warnf("IdP returned an error on callback from %s: %s (%s)", clientAddr(r), idpError, description)
+
+	data := &errorInfo{
+		ClusterName:      cfg.ClusterName,
+		HTTPPath:         cfg.HTTPPath,
+		Error:            idpError,
+		ErrorDescription: description,
+	}
+
+	// only link to absolute http(s) URLs
+	if u, err := url.Parse(r.URL.Query().Get("error_uri")); err == nil && u.IsAbs() &&
+		(u.Scheme == "https" || u.Scheme == "http") {
+		data.ErrorURI = u.String()
+	}
+
+	status := http.StatusBadRequest
+	switch idpError {
+	case "access_denied":
+		status = http.StatusForbidden
+	case "server_error", "temporarily_unavailable":
+		status = http.StatusServiceUnavailable
+	}
+
+	serveTemplateWithStatus("error.tmpl", data, status, w, r)
 }
 
 func commandlineHandler(w http.ResponseWriter, r *http.Request) {
@@ -282,10 +520,45 @@ func commandlineHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	serveTemplate("commandline.tmpl", info, w)
+	var err error
+	info.CSRFToken, err = csrfToken(w, r)
+	if err != nil {
+		log.Errorf("failed to create CSRF token: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if cfg.ShowClaims {
+		info.StandardClaims, info.CustomClaims, err = claimsYAML(info.Claims)
+		if err != nil {
+			log.Errorf("failed to render claims: %v", err)
+		}
+	}
+
+	// every command gets a link of its own as each can only be used once
+	info.SnippetMode = cfg.SnippetMode
+	info.RBACPreview = cfg.EnableRBACPreview
+	if cfg.EnableDownloadLinks {
+		info.DownloadURL = mintDownloadLinkOrLog(r, info)
+	}
+	if cfg.SnippetMode == config.SnippetModeBootstrap {
+		info.BootstrapURL = mintDownloadLinkOrLog(r, info)
+	}
+	if downloadLinks != nil {
+		info.DownloadLinkTTL = downloadLinks.TTL()
+	}
+
+	serveTemplate("commandline.tmpl", info, w, r)
 }
 
 func kubeConfigHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Vary", "Accept")
+	f, err := negotiateFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	info := generateInfo(w, r)
 	if info == nil {
 		// generateInfo writes to the ResponseWriter if it encounters an error.
@@ -293,19 +566,7 @@ func kubeConfigHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	d, err := yaml.Marshal(generateKubeConfig(info))
-	if err != nil {
-		log.Errorf("Error creating kubeconfig - %s", err.Error())
-		http.Error(w, "Error creating kubeconfig", http.StatusInternalServerError)
-		return
-	}
-
-	// tell the browser the returned content should be downloaded
-	w.Header().Add("Content-Disposition", "Attachment")
-	_, err = w.Write(d)
-	if err != nil {
-		log.Errorf("Failed to write kubeconfig: %v", err)
-	}
+	writeKubeConfig(w, info, f)
 }
 
 func generateInfo(w http.ResponseWriter, r *http.Request) *userInfo {
@@ -323,21 +584,17 @@ func generateInfo(w http.ResponseWriter, r *http.Request) *userInfo {
 
 	rawIDToken, ok := sessionIDToken.Values["id_token"].(string)
 	if !ok {
-		gangwayUserSession.Cleanup(w, r, "gangway")
-		gangwayUserSession.Cleanup(w, r, "gangway_id_token")
-		gangwayUserSession.Cleanup(w, r, "gangway_refresh_token")
+		cleanupSessions(w, r)
 
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		http.Redirect(w, r, externalURL(r, ""), http.StatusTemporaryRedirect)
 		return nil
 	}
 
 	refreshToken, ok := sessionRefreshToken.Values["refresh_token"].(string)
 	if !ok {
-		gangwayUserSession.Cleanup(w, r, "gangway")
-		gangwayUserSession.Cleanup(w, r, "gangway_id_token")
-		gangwayUserSession.Cleanup(w, r, "gangway_refresh_token")
+		cleanupSessions(w, r)
 
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		http.Redirect(w, r, externalURL(r, ""), http.StatusTemporaryRedirect)
 		return nil
 	}
 
@@ -364,10 +621,34 @@ func generateInfo(w http.ResponseWriter, r *http.Request) *userInfo {
 		return nil
 	}
 
-	kubeCfgUser := strings.Join([]string{username, cfg.ClusterName}, "@")
-
 	if cfg.EmailClaim != "" {
-		log.Warn("using the Email Claim config setting is deprecated. Gangway uses `UsernameClaim@ClusterName`. This field will be removed in a future version.")
+		log.Warn("using the Email Claim config setting is deprecated. Gangway uses `kubeconfigUserName` to name the user. This field will be removed in a future version.")
+	}
+
+	data := newClaimsData(username, claims)
+	namespaces := userNamespaces(data)
+	var namespace string
+	if len(namespaces) > 0 {
+		namespace = namespaces[0]
+	}
+
+	kubeCfgCluster, err := kubeConfigClusterName(data)
+	if err != nil {
+		log.Errorf("failed to name kubeconfig cluster: %v", err)
+		http.Error(w, "Could not generate kubeconfig cluster name", http.StatusInternalServerError)
+		return nil
+	}
+	kubeCfgUser, err := kubeConfigUserName(data)
+	if err != nil {
+		log.Errorf("failed to name kubeconfig user: %v", err)
+		http.Error(w, "Could not generate kubeconfig user name", http.StatusInternalServerError)
+		return nil
+	}
+	contexts, err := userContexts(data, namespaces)
+	if err != nil {
+		log.Errorf("failed to name kubeconfig context: %v", err)
+		http.Error(w, "Could not generate kubeconfig context name", http.StatusInternalServerError)
+		return nil
 	}
 
 	issuerURL, ok := claims["iss"].(string)
@@ -376,25 +657,52 @@ func generateInfo(w http.ResponseWriter, r *http.Request) *userInfo {
 		return nil
 	}
 
-	if cfg.ClientSecret == "" {
-		log.Warn("Setting an empty Client Secret should only be done if you have no other option and is an inherent security risk.")
-	}
-
 	info := &userInfo{
-		ClusterName:  cfg.ClusterName,
-		Username:     username,
-		Claims:       claims,
-		KubeCfgUser:  kubeCfgUser,
-		IDToken:      rawIDToken,
-		RefreshToken: refreshToken,
-		ClientID:     cfg.ClientID,
-		ClientSecret: cfg.ClientSecret,
-		IssuerURL:    issuerURL,
-		APIServerURL: cfg.APIServerURL,
-		ClusterCA:    string(cfg.ClusterCA),
-		TrustedCA:    string(cfg.TrustedCA),
-		HTTPPath:     cfg.HTTPPath,
-		ShowClaims:   cfg.ShowClaims,
+		ClusterName:    cfg.ClusterName,
+		Username:       username,
+		Groups:         data.Groups,
+		Claims:         claims,
+		KubeCfgUser:    kubeCfgUser,
+		KubeCfgCluster: kubeCfgCluster,
+		KubeCfgContext: contexts[0].Name,
+		IDToken:        rawIDToken,
+		RefreshToken:   refreshToken,
+		ClientID:       cfg.KubeconfigClientID(),
+		ClientSecret:   cfg.KubeconfigClientSecret(),
+		IssuerURL:      issuerURL,
+		APIServerURL:   cfg.APIServerURL,
+		ClusterCA:      string(cfg.ClusterCA),
+		TrustedCA:      string(cfg.TrustedCA),
+		HTTPPath:       cfg.HTTPPath,
+		ShowClaims:     cfg.ShowClaims,
+		Namespace:      namespace,
+		Namespaces:     namespaces,
+		Contexts:       contexts,
+		Expiry:         idToken.Expiry.UTC(),
+		IssuedAt:       idToken.IssuedAt.UTC(),
+		AuthTime:       timeClaim(claims, "auth_time"),
+		// the tokens of the login, unless replaced by other credentials below
+		CredentialKind:   credentialKindIDToken,
+		CredentialExpiry: idToken.Expiry.UTC(),
+	}
+	if usesCredentialSession() {
+		sessionCredential, err := gangwayUserSession.Session.Get(r, "gangway_credential")
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return nil
+		}
+		if !applyCredential(info, sessionCredential.Values) {
+			cleanupSessions(w, r)
+			http.Redirect(w, r, externalURL(r, ""), http.StatusTemporaryRedirect)
+			return nil
+		}
+	}
+	if cfg.PublicClientExecPlugin {
+		// kubectl logs in by itself, the kubeconfig holds no tokens that expire
+		info.ExecArgs = execPluginArgs(issuerURL, info.ClientID, cfg.TrustedCA, cfg.Scopes)
+		info.CredentialKind = credentialKindExecPlugin
+		info.CredentialExpiry = time.Time{}
 	}
+	info.HasRefreshToken = info.RefreshToken != ""
 	return info
 }
@@ -15,9 +15,12 @@
 package main
 
 import (
+	"html"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jcrood/gangway/internal/config"
 	"github.com/jcrood/gangway/internal/session"
@@ -25,7 +28,7 @@ import (
 )
 
 func testInit() {
-	gangwayUserSession = session.New("test", "0123456789")
+	gangwayUserSession = session.New([]string{"test"}, "0123456789", nil)
 	transportConfig = config.NewTransportConfig([]byte(""))
 
 	oauth2Cfg = &oauth2.Config{
@@ -55,6 +58,111 @@ func TestHomeHandler(t *testing.T) {
 	}
 }
 
+func TestCallbackHandlerIdPError(t *testing.T) {
+	tests := map[string]struct {
+		params             map[string]string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		"access denied": {
+			params: map[string]string{
+				"error":             "access_denied",
+				"error_description": "User is not assigned to this application",
+			},
+			expectedStatusCode: http.StatusForbidden,
+			expectedBody:       "User is not assigned to this application",
+		},
+		"server error": {
+			params: map[string]string{
+				"error": "server_error",
+			},
+			expectedStatusCode: http.StatusServiceUnavailable,
+			expectedBody:       "server_error",
+		},
+		"missing code": {
+			params:             map[string]string{},
+			expectedStatusCode: http.StatusBadRequest,
+			expectedBody:       "missing authorization code",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg = &config.Config{
+				HTTPPath:    "/foo",
+				ClusterName: "cluster1",
+			}
+			testInit()
+
+			state := "Uv38ByGCZU8WP18PmmIdcpVmx00QA3xNe7sEB9Hixkk="
+			req := requestWithSession(t, "/callback", map[string]interface{}{"state": state})
+
+			q := req.URL.Query()
+			q.Add("state", state)
+			for k, v := range tc.params {
+				q.Add(k, v)
+			}
+			req.URL.RawQuery = q.Encode()
+
+			rsp := httptest.NewRecorder()
+			http.HandlerFunc(callbackHandler).ServeHTTP(rsp, req)
+
+			if status := rsp.Code; status != tc.expectedStatusCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tc.expectedStatusCode)
+			}
+			if !strings.Contains(rsp.Body.String(), tc.expectedBody) {
+				t.Errorf("expected body to contain %q, got %q", tc.expectedBody, rsp.Body.String())
+			}
+		})
+	}
+}
+
+func TestCallbackHandlerIdPErrorStateMismatch(t *testing.T) {
+	cfg = &config.Config{
+		HTTPPath: "/foo",
+	}
+	testInit()
+
+	req := requestWithSession(t, "/callback", map[string]interface{}{"state": "expected"})
+	req.URL.RawQuery = "state=forged&error=access_denied&error_description=visit+evil.example.com"
+
+	rsp := httptest.NewRecorder()
+	http.HandlerFunc(callbackHandler).ServeHTTP(rsp, req)
+
+	if rsp.Code != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v", rsp.Code, http.StatusForbidden)
+	}
+	if strings.Contains(rsp.Body.String(), "evil.example.com") {
+		t.Errorf("error description rendered without a valid state")
+	}
+}
+
+// requestWithSession returns a GET request carrying the cookie of a "gangway"
+// session that holds the given values.
+func requestWithSession(t *testing.T, target string, values map[string]interface{}) *http.Request {
+	t.Helper()
+
+	req := httptest.NewRequest("GET", target, nil)
+	session, err := gangwayUserSession.Session.Get(req, "gangway")
+	if err != nil {
+		t.Fatalf("Error getting session: %v", err)
+	}
+	for k, v := range values {
+		session.Values[k] = v
+	}
+
+	rec := httptest.NewRecorder()
+	if err = session.Save(req, rec); err != nil {
+		t.Fatal(err)
+	}
+
+	req = httptest.NewRequest("GET", target, nil)
+	for _, c := range rec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	return req
+}
+
 /*
 func TestCallbackHandler(t *testing.T) {
 	tests := map[string]struct {
@@ -421,7 +529,7 @@ func TestUnauthedCommandlineHandlerRedirect(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	session.New("test", "0123456789")
+	session.New([]string{"test"}, "0123456789", nil)
 
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(commandlineHandler)
@@ -455,3 +563,185 @@ func (f *FakeToken) Exchange(ctx context.Context, code string) (*oauth2.Token, e
 }
 
 */
+
+func TestSessionOptions(t *testing.T) {
+	cfg = &config.Config{
+		RedirectURL:    "https://gangway.example.com/gangway/callback",
+		HTTPPath:       "/gangway",
+		CookieDomain:   "gangway.example.com",
+		CookieSameSite: config.CookieSameSiteStrict,
+		CookieMaxAge:   config.Duration{Duration: time.Hour},
+	}
+
+	opts := sessionOptions()
+	if opts.Path != "/gangway" || opts.Domain != "gangway.example.com" || opts.MaxAge != 3600 {
+		t.Errorf("wrong cookie scope: %+v", opts)
+	}
+	if !opts.Secure || !opts.HttpOnly || opts.SameSite != http.SameSiteStrictMode {
+		t.Errorf("wrong cookie protection: %+v", opts)
+	}
+}
+
+func TestApplyCredentialKind(t *testing.T) {
+	expiry := time.Now().Add(30 * time.Minute).Truncate(time.Second).UTC()
+	cert := testCertificate(t, "alice")
+	issued, err := parseCertificate(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		backend        string
+		tokenType      string
+		values         map[interface{}]interface{}
+		expectedKind   string
+		expectedExpiry time.Time
+		expectBearer   bool
+	}{
+		"serviceaccount": {
+			backend:        config.CredentialBackendServiceAccount,
+			values:         map[interface{}]interface{}{"bearer_token": "sa-token", "expiry": expiry.Unix()},
+			expectedKind:   credentialKindServiceAccount,
+			expectedExpiry: expiry,
+		},
+		"certificate": {
+			backend:        config.CredentialBackendCertificate,
+			values:         map[interface{}]interface{}{"certificate": string(cert), "key": "key"},
+			expectedKind:   credentialKindCertificate,
+			expectedExpiry: issued.NotAfter.UTC(),
+		},
+		"exchanged token": {
+			backend:        config.CredentialBackendOIDC,
+			values:         map[interface{}]interface{}{"token": "cluster-token", "expiry": expiry.Unix()},
+			expectedKind:   credentialKindExchanged,
+			expectedExpiry: expiry,
+		},
+		"exchanged token without expiry": {
+			backend:      config.CredentialBackendOIDC,
+			values:       map[interface{}]interface{}{"token": "cluster-token"},
+			expectedKind: credentialKindExchanged,
+		},
+		"exchanged access token": {
+			backend:      config.CredentialBackendOIDC,
+			tokenType:    config.TokenTypeAccessToken,
+			values:       map[interface{}]interface{}{"token": "opaque"},
+			expectedKind: credentialKindExchanged,
+			expectBearer: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg = &config.Config{CredentialBackend: tc.backend, TokenExchangeTokenType: tc.tokenType}
+			info := &userInfo{
+				IDToken:          "login-token",
+				RefreshToken:     "login-refresh",
+				ClientSecret:     "secret",
+				CredentialKind:   credentialKindIDToken,
+				CredentialExpiry: time.Now(),
+			}
+			if !applyCredential(info, tc.values) {
+				t.Fatal("expected the credentials to be applied")
+			}
+			if info.CredentialKind != tc.expectedKind {
+				t.Errorf("wrong kind: want %s, got %s", tc.expectedKind, info.CredentialKind)
+			}
+			if !info.CredentialExpiry.Equal(tc.expectedExpiry) {
+				t.Errorf("wrong expiry: want %v, got %v", tc.expectedExpiry, info.CredentialExpiry)
+			}
+			if info.RefreshToken != "" {
+				t.Errorf("expected no refresh token of gangway's client, got %q", info.RefreshToken)
+			}
+			if tc.expectBearer && (info.BearerToken != tc.values["token"] || info.IDToken != "") {
+				t.Errorf("expected the exchanged token as bearer token, got %q and ID token %q", info.BearerToken, info.IDToken)
+			}
+			if tc.expectedKind == credentialKindExchanged && !tc.expectBearer && info.IDToken != tc.values["token"] {
+				t.Errorf("expected the exchanged token as ID token, got %q", info.IDToken)
+			}
+			if tc.expectedKind == credentialKindExchanged && info.ClientSecret != "" {
+				t.Errorf("expected no client secret without a refresh token, got %q", info.ClientSecret)
+			}
+		})
+	}
+}
+
+func TestCommandlineTemplateCredential(t *testing.T) {
+	expiry := time.Now().Add(time.Hour)
+
+	tests := map[string]struct {
+		info              *userInfo
+		expectedExpiry    string
+		expectRefreshNote bool
+	}{
+		"id token without refresh token": {
+			info:              &userInfo{IDToken: "tok", CredentialKind: credentialKindIDToken, CredentialExpiry: expiry},
+			expectedExpiry:    "Your ID token expires",
+			expectRefreshNote: true,
+		},
+		"id token with refresh token": {
+			info:           &userInfo{IDToken: "tok", HasRefreshToken: true, CredentialKind: credentialKindIDToken, CredentialExpiry: expiry},
+			expectedExpiry: "Your ID token expires",
+		},
+		"exec plugin": {
+			info: &userInfo{IDToken: "tok", ExecArgs: []string{"oidc-login"}, CredentialKind: credentialKindExecPlugin},
+		},
+		"serviceaccount token": {
+			info:           &userInfo{BearerToken: "tok", CredentialKind: credentialKindServiceAccount, CredentialExpiry: expiry},
+			expectedExpiry: "Your ServiceAccount token expires",
+		},
+		"client certificate": {
+			info:           &userInfo{ClientCertificate: "cert", CredentialKind: credentialKindCertificate, CredentialExpiry: expiry},
+			expectedExpiry: "Your client certificate expires",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg = &config.Config{}
+			tc.info.Expiry = time.Now().Add(time.Minute)
+			rsp := httptest.NewRecorder()
+			serveTemplate("commandline.tmpl", tc.info, rsp, httptest.NewRequest("GET", "/commandline", nil))
+			body := rsp.Body.String()
+
+			if tc.expectedExpiry != "" && !strings.Contains(body, tc.expectedExpiry) {
+				t.Errorf("expected %q in the page", tc.expectedExpiry)
+			}
+			if tc.expectedExpiry == "" && strings.Contains(body, "token-expiry") {
+				t.Error("expected no expiry in the page")
+			}
+			if strings.Contains(body, "offline_access") != tc.expectRefreshNote {
+				t.Errorf("want the offline_access note %v", tc.expectRefreshNote)
+			}
+		})
+	}
+}
+
+func TestCommandlineTemplateQuoting(t *testing.T) {
+	cfg = &config.Config{}
+	hostile := []string{`alice"; touch /tmp/pwned; "`, "bob$(id)", "carol`id`", `dave'; rm -rf ~; '`}
+	info := &userInfo{
+		ClusterName:    "prod",
+		KubeCfgCluster: hostile[0],
+		KubeCfgUser:    hostile[1],
+		KubeCfgContext: hostile[2],
+		Contexts:       []kubeContext{{Name: hostile[2], Namespace: hostile[3]}},
+		IDToken:        "tok",
+		CredentialKind: credentialKindIDToken,
+	}
+
+	rsp := httptest.NewRecorder()
+	serveTemplate("commandline.tmpl", info, rsp, httptest.NewRequest("GET", "/commandline", nil))
+	body := html.UnescapeString(rsp.Body.String())
+
+	for _, value := range hostile {
+		if !strings.Contains(body, shellQuote(value)) {
+			t.Errorf("expected %s quoted for the shell", value)
+		}
+		if !strings.Contains(body, powershellQuote(value)) {
+			t.Errorf("expected %s quoted for PowerShell", value)
+		}
+		if strings.Contains(body, `"`+value+`"`) {
+			t.Errorf("expected %s not to be put in double quotes", value)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	// kubeRestConfig holds gangway's own credentials for the cluster
+	kubeRestConfig *rest.Config
+	// kubeClient talks to the cluster gangway issues credentials for
+	kubeClient kubernetes.Interface
+)
+
+// impersonatedKubeClient returns a client acting as the given user. It is a variable so
+// tests can replace it with a fake clientset.
+var impersonatedKubeClient = func(username string, groups []string) (kubernetes.Interface, error) {
+	restConfig := rest.CopyConfig(kubeRestConfig)
+	restConfig.Impersonate = rest.ImpersonationConfig{
+		UserName: username,
+		Groups:   groups,
+	}
+	return kubernetes.NewForConfig(restConfig)
+}
+
+// newKubeRestConfig loads the kubeconfig at path, or the service account of the pod
+// gangway runs in when path is empty
+func newKubeRestConfig(path string) (*rest.Config, error) {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", path)
+	if err != nil {
+		return nil, err
+	}
+	restConfig.UserAgent = "gangway"
+	return restConfig, nil
+}
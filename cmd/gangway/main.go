@@ -28,9 +28,12 @@ import (
 	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/jcrood/gangway/assets"
 	"github.com/jcrood/gangway/internal/config"
+	"github.com/jcrood/gangway/internal/onetime"
+	"github.com/jcrood/gangway/internal/ratelimit"
 	"github.com/jcrood/gangway/internal/session"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/oauth2"
+	"k8s.io/client-go/kubernetes"
 )
 
 var cfg *config.Config
@@ -45,7 +48,7 @@ var verifier *oidc.IDTokenVerifier
 // wrapper function for http logging
 func httpLogger(fn http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		defer log.Printf("%s %s %s", r.Method, r.URL, r.RemoteAddr)
+		defer log.Printf("%s %s %s", r.Method, r.URL, clientAddr(r))
 		fn(w, r)
 	}
 }
@@ -72,6 +75,9 @@ func main() {
 		log.Errorf("Could not parse config file: %s", err)
 		os.Exit(1)
 	}
+	for _, warning := range cfg.Warnings() {
+		log.Warn(warning)
+	}
 
 	ctx := context.Background()
 	provider, err = oidc.NewProvider(ctx, cfg.ProviderURL)
@@ -81,6 +87,9 @@ func main() {
 	}
 
 	verifier = provider.Verifier(&oidc.Config{ClientID: cfg.ClientID})
+	if cfg.TokenExchangeAudience != "" {
+		exchangeVerifier = provider.Verifier(&oidc.Config{ClientID: cfg.TokenExchangeAudience})
+	}
 
 	oauth2Cfg = &oauth2.Config{
 		ClientID:     cfg.ClientID,
@@ -91,7 +100,27 @@ func main() {
 	}
 
 	transportConfig = config.NewTransportConfig(cfg.TrustedCA)
-	gangwayUserSession = session.New(cfg.SessionSecurityKey, cfg.SessionSalt)
+	if cfg.CredentialBackend != config.CredentialBackendOIDC || cfg.EnableRBACPreview {
+		kubeRestConfig, err = newKubeRestConfig(cfg.ClusterKubeconfigPath)
+		if err == nil {
+			kubeClient, err = kubernetes.NewForConfig(kubeRestConfig)
+		}
+		if err != nil {
+			log.Errorf("Could not create Kubernetes client: %s", err)
+			os.Exit(2)
+		}
+	}
+	gangwayUserSession = session.New(cfg.SessionSecurityKeys(), cfg.SessionSalt, sessionOptions())
+	gangwayUserSession.Session.MaxSize = cfg.CookieMaxSize
+	gangwayUserSession.Session.SetCompression(cfg.CookieCompress)
+
+	if cfg.UseDownloadLinks() {
+		downloadLinks = onetime.NewStore(cfg.DownloadLinkTTL.Duration, maxDownloadLinks, maxDownloadLinksPerUser)
+	}
+
+	if cfg.RateLimit > 0 {
+		loginLimiter = ratelimit.NewLimiter(cfg.RateLimit, cfg.RateLimitBurst, maxRateLimitClients)
+	}
 
 	var assetFs http.FileSystem
 	if cfg.CustomAssetsDir != "" {
@@ -100,14 +129,24 @@ func main() {
 		assetFs = http.FS(assets.FS)
 	}
 
-	http.HandleFunc(cfg.GetRootPathPrefix(), httpLogger(rootPathHandler(homeHandler)))
-	http.HandleFunc(fmt.Sprintf("%s/login", cfg.HTTPPath), httpLogger(loginHandler))
-	http.HandleFunc(fmt.Sprintf("%s/callback", cfg.HTTPPath), httpLogger(callbackHandler))
+	// every page gets the security headers, the assets below stay cacheable
+	http.Handle(cfg.GetRootPathPrefix(), securityHeaders(httpLogger(rootPathHandler(homeHandler))))
+	http.Handle(fmt.Sprintf("%s/login", cfg.HTTPPath), securityHeaders(httpLogger(rateLimited("login", loginHandler))))
+	http.Handle(fmt.Sprintf("%s/callback", cfg.HTTPPath), securityHeaders(httpLogger(rateLimited("callback", callbackHandler))))
+	http.Handle(fmt.Sprintf("%s/dl/", cfg.HTTPPath), securityHeaders(httpLogger(downloadHandler)))
 
 	// middleware'd routes
-	http.Handle(fmt.Sprintf("%s/logout", cfg.HTTPPath), loginRequired(http.HandlerFunc(logoutHandler)))
-	http.Handle(fmt.Sprintf("%s/commandline", cfg.HTTPPath), loginRequired(http.HandlerFunc(commandlineHandler)))
-	http.Handle(fmt.Sprintf("%s/kubeconf", cfg.HTTPPath), loginRequired(http.HandlerFunc(kubeConfigHandler)))
+	http.Handle(fmt.Sprintf("%s/logout", cfg.HTTPPath), securityHeaders(loginRequired(csrfProtect(http.HandlerFunc(logoutHandler)))))
+	http.Handle(fmt.Sprintf("%s/commandline", cfg.HTTPPath), securityHeaders(loginRequired(csrfProtect(http.HandlerFunc(commandlineHandler)))))
+	http.Handle(fmt.Sprintf("%s/kubeconf", cfg.HTTPPath), securityHeaders(loginRequired(csrfProtect(http.HandlerFunc(kubeConfigHandler)))))
+	http.Handle(fmt.Sprintf("%s/kubeconf/merge", cfg.HTTPPath), securityHeaders(loginRequired(csrfProtect(http.HandlerFunc(kubeConfigMergeHandler)))))
+	if cfg.EnableRBACPreview {
+		http.Handle(fmt.Sprintf("%s/rbac", cfg.HTTPPath), securityHeaders(loginRequired(csrfProtect(http.HandlerFunc(rbacPreviewHandler)))))
+	}
+	// not logged, scrapers would drown out the requests of users
+	if cfg.EnableMetrics {
+		http.Handle(fmt.Sprintf("%s/metrics", cfg.HTTPPath), securityHeaders(http.HandlerFunc(metricsHandler)))
+	}
 
 	// assets
 	assetsPath := fmt.Sprintf("%s/assets/", cfg.HTTPPath)
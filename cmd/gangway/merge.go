@@ -0,0 +1,328 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// maxUploadSize limits the size of a kubeconfig uploaded for merging
+const maxUploadSize = 1 << 20
+
+// kubeConfigMergeHandler returns the uploaded kubeconfig with the cluster, context
+// and user entries of this cluster added. All other entries are left as they were.
+func kubeConfigMergeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	info := generateInfo(w, r)
+	if info == nil {
+		// generateInfo writes to the ResponseWriter if it encounters an error.
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	existing, err := readUploadedKubeConfig(r)
+	if err != nil {
+		log.Warnf("Failed to read uploaded kubeconfig: %v", err)
+		http.Error(w, fmt.Sprintf("Could not read uploaded kubeconfig: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	overwrite, _ := strconv.ParseBool(r.FormValue("overwrite"))
+	merged, conflicts := mergeKubeConfig(existing, generateKubeConfig(info), overwrite)
+	if len(conflicts) > 0 {
+		if !overwrite {
+			http.Error(w, fmt.Sprintf("Existing kubeconfig entries differ from the ones issued by gangway: %s. "+
+				"Submit again with overwrite=true to replace them.", strings.Join(conflicts, ", ")), http.StatusConflict)
+			return
+		}
+		w.Header().Set("X-Gangway-Replaced", strings.Join(conflicts, ", "))
+	}
+
+	d, err := yaml.Marshal(merged)
+	if err != nil {
+		log.Errorf("Error creating kubeconfig - %s", err.Error())
+		http.Error(w, "Error creating kubeconfig", http.StatusInternalServerError)
+		return
+	}
+
+	// tell the browser the returned content should be downloaded
+	w.Header().Set("Content-Type", yamlFormat.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "config"}))
+	_, err = w.Write(d)
+	if err != nil {
+		log.Errorf("Failed to write kubeconfig: %v", err)
+	}
+}
+
+// readUploadedKubeConfig reads a kubeconfig either from the "kubeconfig" field of a
+// multipart form or from the raw request body. An empty upload yields an empty config.
+func readUploadedKubeConfig(r *http.Request) (clientcmdapi.Config, error) {
+	var (
+		data []byte
+		err  error
+	)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		var f io.ReadCloser
+		f, _, err = r.FormFile("kubeconfig")
+		if err != nil && err != http.ErrMissingFile {
+			return clientcmdapi.Config{}, err
+		}
+		if f != nil {
+			defer f.Close()
+			data, err = ioutil.ReadAll(f)
+		}
+	} else {
+		data, err = ioutil.ReadAll(r.Body)
+	}
+	if err != nil {
+		return clientcmdapi.Config{}, err
+	}
+
+	return parseKubeConfig(data)
+}
+
+// parseKubeConfig parses a YAML or JSON kubeconfig
+func parseKubeConfig(data []byte) (clientcmdapi.Config, error) {
+	kcfg := clientcmdapi.Config{}
+	if err := yaml.Unmarshal(data, &kcfg); err != nil {
+		return kcfg, err
+	}
+	if kcfg.Kind == "" {
+		kcfg.Kind = "Config"
+	}
+	if kcfg.APIVersion == "" {
+		kcfg.APIVersion = "v1"
+	}
+	return kcfg, nil
+}
+
+// mergeKubeConfig adds the cluster, context and user entries of generated to existing.
+// Entries with the same name are replaced, unless they point somewhere else (a different
+// server, cluster, user or identity provider). Those are reported as conflicts and only
+// replaced when overwrite is set. The current context is only set when existing has none.
+func mergeKubeConfig(existing, generated clientcmdapi.Config, overwrite bool) (clientcmdapi.Config, []string) {
+	var conflicts []string
+
+	clusters := append([]clientcmdapi.NamedCluster(nil), existing.Clusters...)
+	for _, c := range generated.Clusters {
+		i := findCluster(clusters, c.Name)
+		if i < 0 {
+			clusters = append(clusters, c)
+			continue
+		}
+		if clusters[i].Cluster.Server != c.Cluster.Server {
+			conflicts = append(conflicts, fmt.Sprintf("cluster %q", c.Name))
+		}
+		clusters[i] = c
+	}
+
+	contexts := append([]clientcmdapi.NamedContext(nil), existing.Contexts...)
+	for _, c := range generated.Contexts {
+		i := findContext(contexts, c.Name)
+		if i < 0 {
+			contexts = append(contexts, c)
+			continue
+		}
+		if contexts[i].Context.Cluster != c.Context.Cluster || contexts[i].Context.AuthInfo != c.Context.AuthInfo {
+			conflicts = append(conflicts, fmt.Sprintf("context %q", c.Name))
+		}
+		contexts[i] = c
+	}
+
+	authInfos := append([]clientcmdapi.NamedAuthInfo(nil), existing.AuthInfos...)
+	for _, a := range generated.AuthInfos {
+		i := findAuthInfo(authInfos, a.Name)
+		if i < 0 {
+			authInfos = append(authInfos, a)
+			continue
+		}
+		if !sameIdentity(authInfos[i].AuthInfo, a.AuthInfo) {
+			conflicts = append(conflicts, fmt.Sprintf("user %q", a.Name))
+		}
+		authInfos[i] = a
+	}
+
+	if len(conflicts) > 0 && !overwrite {
+		return existing, conflicts
+	}
+
+	merged := existing
+	merged.Clusters = clusters
+	merged.Contexts = contexts
+	merged.AuthInfos = authInfos
+	if merged.CurrentContext == "" {
+		merged.CurrentContext = generated.CurrentContext
+	}
+	return merged, conflicts
+}
+
+func findCluster(clusters []clientcmdapi.NamedCluster, name string) int {
+	for i := range clusters {
+		if clusters[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func findContext(contexts []clientcmdapi.NamedContext, name string) int {
+	for i := range contexts {
+		if contexts[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func findAuthInfo(authInfos []clientcmdapi.NamedAuthInfo, name string) int {
+	for i := range authInfos {
+		if authInfos[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Kinds of credentials a kubeconfig user authenticates with
+const (
+	credentialExec         = "exec"
+	credentialAuthProvider = "auth-provider"
+	credentialCertificate  = "certificate"
+	credentialToken        = "token"
+	credentialBasic        = "basic"
+)
+
+// credentialKind returns the kind of credentials of a user, or an empty string for none
+func credentialKind(a clientcmdapi.AuthInfo) string {
+	switch {
+	case a.Exec != nil:
+		return credentialExec
+	case a.AuthProvider != nil:
+		return credentialAuthProvider
+	case len(a.ClientCertificateData) > 0 || a.ClientCertificate != "":
+		return credentialCertificate
+	case a.Token != "" || a.TokenFile != "":
+		return credentialToken
+	case a.Username != "" || a.Password != "":
+		return credentialBasic
+	}
+	return ""
+}
+
+// sameIdentity reports whether two users authenticate as the same identity, so that
+// replacing one with the other only refreshes their credentials. Users with different
+// kinds of credentials never are. Static credentials are the same identity when they are
+// equal, or when they are a renewed certificate or token for the same subject.
+func sameIdentity(a, b clientcmdapi.AuthInfo) bool {
+	kind := credentialKind(a)
+	if kind != credentialKind(b) {
+		return false
+	}
+
+	switch kind {
+	case credentialExec:
+		return a.Exec.Command == b.Exec.Command && reflect.DeepEqual(a.Exec.Args, b.Exec.Args)
+	case credentialAuthProvider:
+		return a.AuthProvider.Name == b.AuthProvider.Name &&
+			a.AuthProvider.Config["idp-issuer-url"] == b.AuthProvider.Config["idp-issuer-url"] &&
+			a.AuthProvider.Config["client-id"] == b.AuthProvider.Config["client-id"]
+	case credentialCertificate:
+		return a.ClientCertificate == b.ClientCertificate &&
+			sameCertificateSubject(a.ClientCertificateData, b.ClientCertificateData)
+	case credentialToken:
+		return a.TokenFile == b.TokenFile && sameTokenSubject(a.Token, b.Token)
+	case credentialBasic:
+		return a.Username == b.Username
+	}
+	return true
+}
+
+// sameCertificateSubject reports whether two PEM encoded certificates are equal or were
+// issued by the same issuer to the same subject
+func sameCertificateSubject(a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	certA, errA := parseCertificate(a)
+	certB, errB := parseCertificate(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return certA.Subject.String() == certB.Subject.String() && certA.Issuer.String() == certB.Issuer.String()
+}
+
+// parseCertificate parses the first certificate of PEM data
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// sameTokenSubject reports whether two bearer tokens are equal or are JWTs of the same
+// issuer for the same subject. The signatures are not verified, the tokens are only
+// compared to decide whether replacing one with the other is expected.
+func sameTokenSubject(a, b string) bool {
+	if a == b {
+		return true
+	}
+	issA, subA, okA := tokenSubject(a)
+	issB, subB, okB := tokenSubject(b)
+	return okA && okB && issA == issB && subA == subB
+}
+
+// tokenSubject returns the iss and sub claims of a JWT
+func tokenSubject(token string) (string, string, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", "", false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", "", false
+	}
+	var claims struct {
+		Iss string `json:"iss"`
+		Sub string `json:"sub"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Sub == "" {
+		return "", "", false
+	}
+	return claims.Iss, claims.Sub, true
+}
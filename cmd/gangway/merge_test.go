@@ -0,0 +1,304 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"mime/multipart"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api/v1"
+)
+
+func testKubeConfig(cluster, server, user, issuer string) clientcmdapi.Config {
+	return clientcmdapi.Config{
+		Kind:           "Config",
+		APIVersion:     "v1",
+		CurrentContext: cluster,
+		Clusters: []clientcmdapi.NamedCluster{
+			{Name: cluster, Cluster: clientcmdapi.Cluster{Server: server}},
+		},
+		Contexts: []clientcmdapi.NamedContext{
+			{Name: cluster, Context: clientcmdapi.Context{Cluster: cluster, AuthInfo: user}},
+		},
+		AuthInfos: []clientcmdapi.NamedAuthInfo{
+			{Name: user, AuthInfo: clientcmdapi.AuthInfo{AuthProvider: &clientcmdapi.AuthProviderConfig{
+				Name: "oidc",
+				Config: map[string]string{
+					"idp-issuer-url": issuer,
+					"client-id":      "gangway",
+					"id-token":       "token-" + user,
+				},
+			}}},
+		},
+	}
+}
+
+func TestMergeKubeConfig(t *testing.T) {
+	generated := testKubeConfig("prod", "https://prod", "alice@prod", "https://idp")
+
+	tests := map[string]struct {
+		existing          clientcmdapi.Config
+		overwrite         bool
+		expectedConflicts int
+		expectedClusters  []string
+		expectedCurrent   string
+		expectReplaced    bool
+	}{
+		"empty": {
+			existing:         clientcmdapi.Config{},
+			expectedClusters: []string{"prod"},
+			expectedCurrent:  "prod",
+			expectReplaced:   true,
+		},
+		"other cluster kept": {
+			existing:         testKubeConfig("dev", "https://dev", "alice@dev", "https://idp"),
+			expectedClusters: []string{"dev", "prod"},
+			expectedCurrent:  "dev",
+			expectReplaced:   true,
+		},
+		"refresh of earlier download": {
+			existing:         testKubeConfig("prod", "https://prod", "alice@prod", "https://idp"),
+			expectedClusters: []string{"prod"},
+			expectedCurrent:  "prod",
+			expectReplaced:   true,
+		},
+		"clashing names": {
+			existing:          testKubeConfig("prod", "https://elsewhere", "alice@prod", "https://other-idp"),
+			expectedConflicts: 2,
+			expectedClusters:  []string{"prod"},
+			expectedCurrent:   "prod",
+			expectReplaced:    false,
+		},
+		"clashing names with overwrite": {
+			existing:          testKubeConfig("prod", "https://elsewhere", "alice@prod", "https://other-idp"),
+			overwrite:         true,
+			expectedConflicts: 2,
+			expectedClusters:  []string{"prod"},
+			expectedCurrent:   "prod",
+			expectReplaced:    true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			merged, conflicts := mergeKubeConfig(tc.existing, generated, tc.overwrite)
+			if len(conflicts) != tc.expectedConflicts {
+				t.Errorf("expected %d conflicts, got %v", tc.expectedConflicts, conflicts)
+			}
+
+			var clusters []string
+			for _, c := range merged.Clusters {
+				clusters = append(clusters, c.Name)
+			}
+			if !reflect.DeepEqual(clusters, tc.expectedClusters) {
+				t.Errorf("expected clusters %v, got %v", tc.expectedClusters, clusters)
+			}
+			if merged.CurrentContext != tc.expectedCurrent {
+				t.Errorf("expected current context %q, got %q", tc.expectedCurrent, merged.CurrentContext)
+			}
+
+			i := findCluster(merged.Clusters, "prod")
+			replaced := merged.Clusters[i].Cluster.Server == "https://prod"
+			if replaced != tc.expectReplaced {
+				t.Errorf("expected cluster replaced to be %t, got %t", tc.expectReplaced, replaced)
+			}
+		})
+	}
+}
+
+func TestMergeKubeConfigLeavesExistingUntouched(t *testing.T) {
+	existing := testKubeConfig("prod", "https://elsewhere", "alice@prod", "https://other-idp")
+	generated := testKubeConfig("prod", "https://prod", "alice@prod", "https://idp")
+
+	mergeKubeConfig(existing, generated, true)
+	if existing.Clusters[0].Cluster.Server != "https://elsewhere" {
+		t.Errorf("mergeKubeConfig modified the existing kubeconfig")
+	}
+}
+
+func TestReadUploadedKubeConfig(t *testing.T) {
+	data := []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: dev
+  cluster:
+    server: https://dev
+`)
+
+	t.Run("raw body", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/kubeconf/merge", bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/yaml")
+		kcfg, err := readUploadedKubeConfig(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(kcfg.Clusters) != 1 || kcfg.Clusters[0].Cluster.Server != "https://dev" {
+			t.Errorf("unexpected clusters: %v", kcfg.Clusters)
+		}
+	})
+
+	t.Run("multipart form", func(t *testing.T) {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		fw, err := mw.CreateFormFile("kubeconfig", "config")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = fw.Write(data)
+		_ = mw.Close()
+
+		req := httptest.NewRequest("POST", "/kubeconf/merge", body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		kcfg, err := readUploadedKubeConfig(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(kcfg.Clusters) != 1 || kcfg.Clusters[0].Name != "dev" {
+			t.Errorf("unexpected clusters: %v", kcfg.Clusters)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/kubeconf/merge", nil)
+		kcfg, err := readUploadedKubeConfig(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if kcfg.Kind != "Config" || len(kcfg.Clusters) != 0 {
+			t.Errorf("unexpected kubeconfig: %v", kcfg)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/kubeconf/merge", bytes.NewReader([]byte("clusters: {")))
+		if _, err := readUploadedKubeConfig(req); err == nil {
+			t.Errorf("expected an error for an invalid kubeconfig")
+		}
+	})
+}
+
+func TestSameIdentityExecPlugin(t *testing.T) {
+	exec := func(clientID string) clientcmdapi.AuthInfo {
+		return clientcmdapi.AuthInfo{Exec: execPluginConfig(execPluginArgs("https://idp", clientID, nil, nil))}
+	}
+	oidc := testKubeConfig("prod", "https://prod", "alice@prod", "https://idp").AuthInfos[0].AuthInfo
+
+	if !sameIdentity(exec("kubectl"), exec("kubectl")) {
+		t.Errorf("expected the same exec plugin to be the same identity")
+	}
+	if sameIdentity(exec("kubectl"), exec("other")) {
+		t.Errorf("expected exec plugins for different clients to differ")
+	}
+	if sameIdentity(exec("kubectl"), oidc) || sameIdentity(oidc, exec("kubectl")) {
+		t.Errorf("expected the exec plugin and the auth provider to differ")
+	}
+}
+
+// testCertificate returns a self-signed certificate for the common name, PEM encoded
+func testCertificate(t *testing.T, commonName string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"dev"}},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// testJWT returns an unsigned JWT with the iss and sub claims
+func testJWT(iss, sub string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"iss":%q,"sub":%q}`, iss, sub)))
+	return "eyJhbGciOiJSUzI1NiJ9." + payload + ".c2lnbmF0dXJl"
+}
+
+func TestSameIdentityStaticCredentials(t *testing.T) {
+	token := func(token string) clientcmdapi.AuthInfo { return clientcmdapi.AuthInfo{Token: token} }
+	cert := func(data []byte) clientcmdapi.AuthInfo { return clientcmdapi.AuthInfo{ClientCertificateData: data} }
+	alice := testCertificate(t, "alice")
+	bob := testCertificate(t, "bob")
+	sa := "system:serviceaccount:users:developer"
+
+	tests := []struct {
+		name string
+		a, b clientcmdapi.AuthInfo
+		same bool
+	}{
+		{"same token", token("abc"), token("abc"), true},
+		{"different tokens", token("abc"), token("def"), false},
+		{"renewed token", token(testJWT("https://k8s", sa)), token(testJWT("https://k8s", sa) + "x"), true},
+		{"token of another subject", token(testJWT("https://k8s", sa)), token(testJWT("https://k8s", "system:serviceaccount:users:admin")), false},
+		{"token of another issuer", token(testJWT("https://k8s", sa)), token(testJWT("https://other", sa)), false},
+		{"same certificate", cert(alice), cert(alice), true},
+		{"renewed certificate", cert(alice), cert(testCertificate(t, "alice")), true},
+		{"certificate of another user", cert(alice), cert(bob), false},
+		{"token and certificate", token("abc"), cert(alice), false},
+		{"token and auth provider", token("abc"), testKubeConfig("prod", "https://prod", "alice@prod", "https://idp").AuthInfos[0].AuthInfo, false},
+		{"no credentials", clientcmdapi.AuthInfo{}, clientcmdapi.AuthInfo{}, true},
+	}
+
+	for _, tc := range tests {
+		if got := sameIdentity(tc.a, tc.b); got != tc.same {
+			t.Errorf("%s: want %t, got %t", tc.name, tc.same, got)
+		}
+	}
+}
+
+func TestMergeKubeConfigStaticCredentials(t *testing.T) {
+	withUser := func(authInfo clientcmdapi.AuthInfo) clientcmdapi.Config {
+		config := testKubeConfig("prod", "https://prod", "alice@prod", "https://idp")
+		config.AuthInfos[0].AuthInfo = authInfo
+		return config
+	}
+
+	_, conflicts := mergeKubeConfig(withUser(clientcmdapi.AuthInfo{Token: "ci-token"}), withUser(clientcmdapi.AuthInfo{Token: "other-token"}), false)
+	if len(conflicts) != 1 {
+		t.Errorf("expected a conflict for a different token user, got %v", conflicts)
+	}
+
+	existing := withUser(clientcmdapi.AuthInfo{ClientCertificateData: testCertificate(t, "bob")})
+	_, conflicts = mergeKubeConfig(existing, withUser(clientcmdapi.AuthInfo{ClientCertificateData: testCertificate(t, "alice")}), false)
+	if len(conflicts) != 1 {
+		t.Errorf("expected a conflict for a certificate user of someone else, got %v", conflicts)
+	}
+
+	existing = withUser(clientcmdapi.AuthInfo{ClientCertificateData: testCertificate(t, "alice")})
+	generated := withUser(clientcmdapi.AuthInfo{ClientCertificateData: testCertificate(t, "alice")})
+	merged, conflicts := mergeKubeConfig(existing, generated, false)
+	if len(conflicts) != 0 || !bytes.Equal(merged.AuthInfos[0].AuthInfo.ClientCertificateData, generated.AuthInfos[0].AuthInfo.ClientCertificateData) {
+		t.Errorf("expected a renewed certificate to replace the earlier one, got %v", conflicts)
+	}
+}
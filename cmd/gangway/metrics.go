@@ -0,0 +1,119 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Counters are served in the Prometheus text format by metricsHandler, when enableMetrics
+// is set. expvar is deliberately not used: importing it publishes /debug/vars, with the
+// command line and memory statistics of the process, on the default mux.
+var (
+	// callbackErrors counts error responses from the IdP, keyed by OAuth2 error code
+	callbackErrors = newCounterVec("gangway_callback_errors_total", "Error responses from the identity provider.", "error")
+	// rateLimitedRequests counts the requests refused by the rate limiter, keyed by route
+	rateLimitedRequests = newCounterVec("gangway_rate_limited_requests_total", "Requests refused by the rate limiter.", "route")
+
+	metrics = []*counterVec{callbackErrors, rateLimitedRequests}
+)
+
+// counterVec is a set of counters told apart by the value of a single label
+type counterVec struct {
+	name  string
+	help  string
+	label string
+
+	mu     sync.Mutex
+	values map[string]int64
+}
+
+func newCounterVec(name, help, label string) *counterVec {
+	return &counterVec{name: name, help: help, label: label, values: make(map[string]int64)}
+}
+
+// Add adds delta to the counter for value
+func (c *counterVec) Add(value string, delta int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[value] += delta
+}
+
+// Value returns the counter for value
+func (c *counterVec) Value(value string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[value]
+}
+
+// writeTo writes the counters in the Prometheus text format
+func (c *counterVec) writeTo(b *strings.Builder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	values := make([]string, 0, len(c.values))
+	for value := range c.values {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, value := range values {
+		fmt.Fprintf(b, "%s{%s=%q} %d\n", c.name, c.label, value, c.values[value])
+	}
+}
+
+// metricsHandler serves the counters in the Prometheus text format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	for _, c := range metrics {
+		c.writeTo(&b)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprint(w, b.String())
+}
+
+// knownOAuth2Errors are the error codes defined by RFC 6749 and OpenID Connect Core.
+// Anything else is counted as "other" to keep the number of metric labels bounded.
+var knownOAuth2Errors = map[string]bool{
+	"access_denied":              true,
+	"invalid_request":            true,
+	"unauthorized_client":        true,
+	"unsupported_response_type":  true,
+	"invalid_scope":              true,
+	"server_error":               true,
+	"temporarily_unavailable":    true,
+	"interaction_required":       true,
+	"login_required":             true,
+	"account_selection_required": true,
+	"consent_required":           true,
+	"invalid_request_uri":        true,
+	"invalid_request_object":     true,
+	"request_not_supported":      true,
+	"request_uri_not_supported":  true,
+	"registration_not_supported": true,
+}
+
+// oauth2ErrorLabel returns a bounded metric label for an OAuth2 error code
+func oauth2ErrorLabel(code string) string {
+	if knownOAuth2Errors[code] {
+		return code
+	}
+	return "other"
+}
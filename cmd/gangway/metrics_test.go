@@ -0,0 +1,51 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	before := callbackErrors.Value("access_denied")
+	callbackErrors.Add(oauth2ErrorLabel("access_denied"), 1)
+	callbackErrors.Add(oauth2ErrorLabel("made_up"), 1)
+	if v := callbackErrors.Value("access_denied"); v != before+1 {
+		t.Errorf("want %d access_denied errors, got %d", before+1, v)
+	}
+
+	rsp := httptest.NewRecorder()
+	metricsHandler(rsp, httptest.NewRequest("GET", "/metrics", nil))
+	body := rsp.Body.String()
+
+	if ct := rsp.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("wrong content type %q", ct)
+	}
+	for _, want := range []string{
+		"# TYPE gangway_callback_errors_total counter\n",
+		`gangway_callback_errors_total{error="access_denied"} `,
+		`gangway_callback_errors_total{error="other"} `,
+		"# TYPE gangway_rate_limited_requests_total counter\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in the metrics, got\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "made_up") {
+		t.Errorf("expected unknown error codes to be counted as other, got\n%s", body)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"regexp"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// namespacePattern matches a valid Kubernetes namespace name (an RFC 1123 label)
+var namespacePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+// userNamespaces returns the namespaces a user is entitled to, with the default
+// namespace first. The default is the result of the namespaceTemplate, or else the
+// namespace of the first group in groupNamespaces the user is a member of.
+func userNamespaces(data *claimsData) []string {
+	var namespaces []string
+	add := func(ns string) {
+		if !namespacePattern.MatchString(ns) {
+			log.Warnf("ignoring invalid namespace %q for user %s", ns, data.Username)
+			return
+		}
+		for _, n := range namespaces {
+			if n == ns {
+				return
+			}
+		}
+		namespaces = append(namespaces, ns)
+	}
+
+	if cfg.NamespaceTemplate != "" {
+		// claims missing from the token result in an error rather than a
+		// namespace containing "<no value>"
+		ns, err := executeTextTemplate("namespaceTemplate", cfg.NamespaceTemplate, data)
+		if err != nil {
+			log.Debugf("no namespace from namespaceTemplate for user %s: %v", data.Username, err)
+		} else if ns != "" {
+			add(ns)
+		}
+	}
+
+	for _, group := range data.Groups {
+		if ns, ok := cfg.GroupNamespaces[group]; ok {
+			add(ns)
+		}
+	}
+	return namespaces
+}
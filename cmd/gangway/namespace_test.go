@@ -0,0 +1,76 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jcrood/gangway/internal/config"
+)
+
+func TestUserNamespaces(t *testing.T) {
+	claims := map[string]interface{}{
+		"team":   "platform",
+		"groups": []interface{}{"dev", "ops", "admins"},
+	}
+
+	tests := map[string]struct {
+		namespaceTemplate string
+		groupNamespaces   map[string]string
+		want              []string
+	}{
+		"nothing configured": {
+			want: nil,
+		},
+		"template": {
+			namespaceTemplate: "team-{{ .Claims.team }}",
+			want:              []string{"team-platform"},
+		},
+		"template with missing claim": {
+			namespaceTemplate: "team-{{ .Claims.department }}",
+			want:              nil,
+		},
+		"template with invalid result": {
+			namespaceTemplate: "Team {{ .Claims.team }}",
+			want:              nil,
+		},
+		"group lookup in groups claim order": {
+			groupNamespaces: map[string]string{"admins": "kube-system", "ops": "monitoring"},
+			want:            []string{"monitoring", "kube-system"},
+		},
+		"template first, then groups": {
+			namespaceTemplate: "team-{{ .Claims.team }}",
+			groupNamespaces:   map[string]string{"dev": "team-platform", "ops": "monitoring"},
+			want:              []string{"team-platform", "monitoring"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg = &config.Config{
+				ClusterName:       "cluster1",
+				GroupsClaim:       "groups",
+				NamespaceTemplate: tc.namespaceTemplate,
+				GroupNamespaces:   tc.groupNamespaces,
+			}
+
+			got := userNamespaces(newClaimsData("alice", claims))
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("userNamespaces(): want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
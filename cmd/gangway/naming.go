@@ -0,0 +1,121 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/jcrood/gangway/internal/config"
+)
+
+// claimsData is the data the namespace and naming templates are evaluated against
+type claimsData struct {
+	ClusterName  string
+	APIServerURL string
+	Username     string
+	Namespace    string
+	Groups       []string
+	Claims       map[string]interface{}
+}
+
+// kubeContext describes a context in the generated kubeconfig
+type kubeContext struct {
+	Name      string
+	Namespace string
+}
+
+// newClaimsData returns the template data for an authenticated user
+func newClaimsData(username string, claims map[string]interface{}) *claimsData {
+	return &claimsData{
+		ClusterName:  cfg.ClusterName,
+		APIServerURL: cfg.APIServerURL,
+		Username:     username,
+		Groups:       stringsClaim(claims, cfg.GroupsClaim),
+		Claims:       claims,
+	}
+}
+
+// kubeConfigClusterName returns the name of the cluster entry in the kubeconfig
+func kubeConfigClusterName(data *claimsData) (string, error) {
+	return executeNameTemplate("kubeconfigClusterName", cfg.KubeconfigClusterName, config.DefaultKubeconfigClusterName, data)
+}
+
+// kubeConfigUserName returns the name of the user entry in the kubeconfig
+func kubeConfigUserName(data *claimsData) (string, error) {
+	return executeNameTemplate("kubeconfigUserName", cfg.KubeconfigUserName, config.DefaultKubeconfigUserName, data)
+}
+
+// userContexts returns the contexts to generate for the given namespaces. The first
+// context uses the default namespace. With contextPerNamespace set, every other
+// namespace gets a context of its own. Context names come from kubeconfigContextName,
+// evaluated with .Namespace set; when the pattern does not use the namespace, the
+// additional contexts get the namespace appended to keep their names unique.
+func userContexts(data *claimsData, namespaces []string) ([]kubeContext, error) {
+	candidates := []string{""}
+	if len(namespaces) > 0 {
+		candidates = []string{namespaces[0]}
+		if cfg.ContextPerNamespace {
+			candidates = namespaces
+		}
+	}
+
+	contexts := make([]kubeContext, 0, len(candidates))
+	for i, ns := range candidates {
+		d := *data
+		d.Namespace = ns
+		name, err := executeNameTemplate("kubeconfigContextName", cfg.KubeconfigContextName, config.DefaultKubeconfigContextName, &d)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 && name == contexts[0].Name {
+			name = name + "-" + ns
+		}
+		contexts = append(contexts, kubeContext{Name: name, Namespace: ns})
+	}
+	return contexts, nil
+}
+
+// executeNameTemplate evaluates a naming pattern, using def when none is configured
+func executeNameTemplate(name, text, def string, data *claimsData) (string, error) {
+	if text == "" {
+		text = def
+	}
+
+	value, err := executeTextTemplate(name, text, data)
+	if err != nil {
+		return "", err
+	}
+	if value == "" {
+		return "", fmt.Errorf("%s evaluated to an empty name", name)
+	}
+	return value, nil
+}
+
+// executeTextTemplate evaluates a template from the config. Referencing a key
+// missing from a map, such as an absent claim, is an error.
+func executeTextTemplate(name, text string, data interface{}) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	if err = tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
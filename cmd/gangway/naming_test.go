@@ -0,0 +1,170 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jcrood/gangway/internal/config"
+)
+
+func TestKubeConfigNames(t *testing.T) {
+	claims := map[string]interface{}{
+		"email": "alice@example.com",
+		"team":  "platform",
+	}
+
+	tests := map[string]struct {
+		cfg             config.Config
+		expectedCluster string
+		expectedUser    string
+		expectError     bool
+	}{
+		"defaults": {
+			cfg:             config.Config{ClusterName: "prod-eu"},
+			expectedCluster: "prod-eu",
+			expectedUser:    "alice@prod-eu",
+		},
+		"patterns over claims and cluster settings": {
+			cfg: config.Config{
+				ClusterName:           "prod-eu",
+				APIServerURL:          "https://k8s.example.com",
+				KubeconfigClusterName: "oidc-{{ .ClusterName }}",
+				KubeconfigUserName:    "{{ .Claims.email }}-{{ .Claims.team }}",
+			},
+			expectedCluster: "oidc-prod-eu",
+			expectedUser:    "alice@example.com-platform",
+		},
+		"missing claim": {
+			cfg: config.Config{
+				ClusterName:        "prod-eu",
+				KubeconfigUserName: "{{ .Claims.department }}",
+			},
+			expectedCluster: "prod-eu",
+			expectError:     true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg = &tc.cfg
+			data := newClaimsData("alice", claims)
+
+			cluster, err := kubeConfigClusterName(data)
+			if err != nil {
+				t.Fatalf("kubeConfigClusterName(): unexpected error: %v", err)
+			}
+			if cluster != tc.expectedCluster {
+				t.Errorf("kubeConfigClusterName(): want %q, got %q", tc.expectedCluster, cluster)
+			}
+
+			user, err := kubeConfigUserName(data)
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("kubeConfigUserName(): expected an error, got %q", user)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("kubeConfigUserName(): unexpected error: %v", err)
+			}
+			if user != tc.expectedUser {
+				t.Errorf("kubeConfigUserName(): want %q, got %q", tc.expectedUser, user)
+			}
+		})
+	}
+}
+
+func TestUserContexts(t *testing.T) {
+	tests := map[string]struct {
+		contextName         string
+		contextPerNamespace bool
+		namespaces          []string
+		want                []kubeContext
+	}{
+		"no namespace": {
+			want: []kubeContext{{Name: "cluster1"}},
+		},
+		"namespace in name": {
+			contextName:         "{{ .ClusterName }}/{{ .Namespace }}",
+			contextPerNamespace: true,
+			namespaces:          []string{"team-a", "team-b"},
+			want: []kubeContext{
+				{Name: "cluster1/team-a", Namespace: "team-a"},
+				{Name: "cluster1/team-b", Namespace: "team-b"},
+			},
+		},
+		"default namespace": {
+			namespaces: []string{"team-a", "team-b"},
+			want:       []kubeContext{{Name: "cluster1", Namespace: "team-a"}},
+		},
+		"context per namespace": {
+			contextPerNamespace: true,
+			namespaces:          []string{"team-a", "team-b"},
+			want: []kubeContext{
+				{Name: "cluster1", Namespace: "team-a"},
+				{Name: "cluster1-team-b", Namespace: "team-b"},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg = &config.Config{
+				ClusterName:           "cluster1",
+				ContextPerNamespace:   tc.contextPerNamespace,
+				KubeconfigContextName: tc.contextName,
+			}
+
+			got, err := userContexts(newClaimsData("alice", nil), tc.namespaces)
+			if err != nil {
+				t.Fatalf("userContexts(): unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("userContexts(): want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGenerateKubeConfigNamespaces(t *testing.T) {
+	info := &userInfo{
+		ClusterName:    "cluster1",
+		KubeCfgUser:    "alice@cluster1",
+		KubeCfgCluster: "cluster1",
+		KubeCfgContext: "cluster1",
+		Contexts: []kubeContext{
+			{Name: "cluster1", Namespace: "team-a"},
+			{Name: "cluster1-team-b", Namespace: "team-b"},
+		},
+	}
+
+	kcfg := generateKubeConfig(info)
+	if len(kcfg.Contexts) != 2 {
+		t.Fatalf("Found %d contexts in the generated kubeconfig, expected 2", len(kcfg.Contexts))
+	}
+	if kcfg.CurrentContext != "cluster1" {
+		t.Errorf("Expected current context %q, got %q", "cluster1", kcfg.CurrentContext)
+	}
+	for i, c := range kcfg.Contexts {
+		if c.Name != info.Contexts[i].Name || c.Context.Namespace != info.Contexts[i].Namespace {
+			t.Errorf("Unexpected context %v", c)
+		}
+		if c.Context.Cluster != "cluster1" || c.Context.AuthInfo != "alice@cluster1" {
+			t.Errorf("Context %q does not point at the generated cluster and user", c.Name)
+		}
+	}
+}
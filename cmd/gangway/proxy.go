@@ -0,0 +1,164 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// origin describes a request as it was received from the client: the address of the client
+// and, when a trusted proxy sent them, the scheme and host the client used. It also
+// describes the hops of a forwarded request.
+type origin struct {
+	ip    net.IP
+	proto string
+	host  string
+}
+
+// requestOrigin returns the origin of a request. The Forwarded header, or else the
+// X-Forwarded-For, -Proto and -Host headers, are only followed for connections from
+// trustedProxies, from the last hop back to the first address that is not a trusted proxy.
+func requestOrigin(r *http.Request) origin {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	o := origin{ip: net.ParseIP(host)}
+	if o.ip == nil {
+		return o
+	}
+
+	proxies, err := cfg.TrustedProxyNets()
+	if err != nil {
+		log.Errorf("invalid trustedProxies: %v", err)
+		return o
+	}
+	if !containsIP(proxies, o.ip) {
+		return o
+	}
+
+	hops := forwardedHops(r)
+	for i := len(hops) - 1; i >= 0; i-- {
+		if hops[i].ip == nil {
+			// the chain cannot be followed further, the last proxy is all we know
+			break
+		}
+		o = hops[i]
+		if !containsIP(proxies, o.ip) {
+			break
+		}
+	}
+	return o
+}
+
+// forwardedHops returns the hops a request was forwarded through, the client first. They
+// are taken from the RFC 7239 Forwarded header when present, or else from X-Forwarded-For,
+// with the scheme and host of the X-Forwarded-Proto and -Host set by the last proxy.
+func forwardedHops(r *http.Request) []origin {
+	if forwarded := headerList(r, "Forwarded"); len(forwarded) > 0 {
+		hops := make([]origin, len(forwarded))
+		for i, element := range forwarded {
+			hops[i] = parseForwardedElement(element)
+		}
+		return hops
+	}
+
+	addrs := headerList(r, "X-Forwarded-For")
+	hops := make([]origin, len(addrs))
+	for i, addr := range addrs {
+		hops[i].ip = net.ParseIP(addr)
+	}
+	// the scheme and host are those the last proxy passed on, whatever the proxies before it got
+	proto := lastValue(headerList(r, "X-Forwarded-Proto"))
+	host := lastValue(headerList(r, "X-Forwarded-Host"))
+	for i := range hops {
+		hops[i].proto, hops[i].host = strings.ToLower(proto), host
+	}
+	return hops
+}
+
+// parseForwardedElement parses one element of a Forwarded header, e.g.
+// for="[2001:db8::1]:4711";proto=https;host=gangway.example.com
+func parseForwardedElement(element string) origin {
+	var hop origin
+	for _, pair := range strings.Split(element, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"`)
+		switch strings.ToLower(key) {
+		case "for":
+			// obfuscated identifiers and "unknown" leave the address unset
+			if h, _, err := net.SplitHostPort(value); err == nil {
+				value = h
+			}
+			hop.ip = net.ParseIP(strings.Trim(value, "[]"))
+		case "proto":
+			hop.proto = strings.ToLower(value)
+		case "host":
+			hop.host = value
+		}
+	}
+	return hop
+}
+
+// headerList returns the comma separated values of all header fields named key
+func headerList(r *http.Request, key string) []string {
+	var values []string
+	for _, field := range r.Header.Values(key) {
+		for _, v := range strings.Split(field, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+// lastValue returns the last of values, or an empty string
+func lastValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[len(values)-1]
+}
+
+// clientIP returns the address of the client behind a request
+func clientIP(r *http.Request) net.IP {
+	return requestOrigin(r).ip
+}
+
+// clientAddr returns the address of the client behind a request for logging
+func clientAddr(r *http.Request) string {
+	if ip := clientIP(r); ip != nil {
+		return ip.String()
+	}
+	return r.RemoteAddr
+}
+
+// containsIP returns whether ip is in any of the networks
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jcrood/gangway/internal/config"
+)
+
+func TestRequestOrigin(t *testing.T) {
+	cfg = &config.Config{TrustedProxies: []string{"10.0.0.0/8", "fd00::/8"}}
+
+	tests := map[string]struct {
+		remoteAddr string
+		header     http.Header
+		ip         string
+		proto      string
+		host       string
+	}{
+		"direct": {
+			remoteAddr: "192.0.2.10:1234",
+			ip:         "192.0.2.10",
+		},
+		"untrusted proxy": {
+			remoteAddr: "192.0.2.10:1234",
+			header:     http.Header{"X-Forwarded-For": {"198.51.100.1"}, "X-Forwarded-Host": {"evil.example.com"}},
+			ip:         "192.0.2.10",
+		},
+		"trusted proxy": {
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"X-Forwarded-For":   {"198.51.100.1"},
+				"X-Forwarded-Proto": {"HTTPS"},
+				"X-Forwarded-Host":  {"gangway.example.com"},
+			},
+			ip:    "198.51.100.1",
+			proto: "https",
+			host:  "gangway.example.com",
+		},
+		"chain of trusted proxies": {
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Forwarded-For": {"198.51.100.1, 10.1.1.1", "10.2.2.2"}},
+			ip:         "198.51.100.1",
+		},
+		"spoofed first hop": {
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Forwarded-For": {"203.0.113.66, 198.51.100.1"}},
+			ip:         "198.51.100.1",
+		},
+		"trusted proxy without XFF": {
+			remoteAddr: "10.0.0.1:1234",
+			ip:         "10.0.0.1",
+		},
+		"garbage hop": {
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Forwarded-For": {"198.51.100.1, unknown"}},
+			ip:         "10.0.0.1",
+		},
+		"IPv6 proxy": {
+			remoteAddr: "[fd00::1]:1234",
+			header:     http.Header{"X-Forwarded-For": {"2001:db8::1"}},
+			ip:         "2001:db8::1",
+		},
+		"forwarded": {
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"Forwarded":       {`for=203.0.113.66;host=evil.example.com, for="[2001:db8::1]:4711";proto=https;host=gangway.example.com`, "for=10.1.1.1;proto=http;host=gangway.internal"},
+				"X-Forwarded-For": {"192.0.2.99"},
+			},
+			ip:    "2001:db8::1",
+			proto: "https",
+			host:  "gangway.example.com",
+		},
+		"forwarded obfuscated": {
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"Forwarded": {"for=_hidden;proto=https"}},
+			ip:         "10.0.0.1",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/login", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for k, v := range tc.header {
+				r.Header[k] = v
+			}
+			o := requestOrigin(r)
+			if o.ip.String() != tc.ip || o.proto != tc.proto || o.host != tc.host {
+				t.Errorf("want %s %s %s, got %s %s %s", tc.ip, tc.proto, tc.host, o.ip, o.proto, o.host)
+			}
+		})
+	}
+}
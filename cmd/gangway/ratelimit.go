@@ -0,0 +1,66 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+
+	"github.com/jcrood/gangway/internal/ratelimit"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxRateLimitClients limits the number of clients tracked by the rate limiter
+const maxRateLimitClients = 10000
+
+// loginLimiter limits the requests to /login and /callback per client, it is nil when
+// rate limiting is disabled. Both share a bucket as every login takes one request to each.
+var loginLimiter *ratelimit.Limiter
+
+// rateLimitKey returns the rate limiting key of a client. IPv6 clients usually get a whole
+// /64, so that is what they are limited by.
+func rateLimitKey(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	if ip.To4() == nil {
+		return ip.Mask(net.CIDRMask(64, 128)).String()
+	}
+	return ip.String()
+}
+
+// rateLimited answers requests beyond the loginLimiter with 429 Too Many Requests, counting
+// them in rateLimitedRequests under route
+func rateLimited(route string, fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if loginLimiter == nil {
+			fn(w, r)
+			return
+		}
+
+		ip := clientIP(r)
+		ok, wait := loginLimiter.Allow(rateLimitKey(ip))
+		if !ok {
+			rateLimitedRequests.Add(route, 1)
+			log.Debugf("rate limited %s %s from %s", r.Method, r.URL.Path, ip)
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int64(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too many requests, try again later", http.StatusTooManyRequests)
+			return
+		}
+		fn(w, r)
+	}
+}
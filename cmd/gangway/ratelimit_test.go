@@ -0,0 +1,70 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jcrood/gangway/internal/config"
+	"github.com/jcrood/gangway/internal/ratelimit"
+)
+
+func TestRateLimited(t *testing.T) {
+	cfg = &config.Config{}
+	loginLimiter = ratelimit.NewLimiter(1, 2, maxRateLimitClients)
+	defer func() { loginLimiter = nil }()
+
+	handler := rateLimited("login", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	before := rateLimitedRequests.Value("login")
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		rsp := httptest.NewRecorder()
+		handler(rsp, httptest.NewRequest("GET", "/login", nil))
+		if rsp.Code != want {
+			t.Fatalf("request %d: want status %d, got %d", i+1, want, rsp.Code)
+		}
+		if want == http.StatusTooManyRequests && rsp.Header().Get("Retry-After") != "60" {
+			t.Errorf("want Retry-After 60, got %q", rsp.Header().Get("Retry-After"))
+		}
+	}
+	if v := rateLimitedRequests.Value("login"); v != before+1 {
+		t.Errorf("want %d rate limited requests, got %d", before+1, v)
+	}
+
+	// other clients are not affected
+	rsp := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/login", nil)
+	r.RemoteAddr = "192.0.2.99:1234"
+	handler(rsp, r)
+	if rsp.Code != http.StatusOK {
+		t.Errorf("want another client to be allowed, got %d", rsp.Code)
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	if key := rateLimitKey(net.ParseIP("192.0.2.1")); key != "192.0.2.1" {
+		t.Errorf("want IPv4 clients limited by address, got %s", key)
+	}
+	a := rateLimitKey(net.ParseIP("2001:db8:1:2::1"))
+	b := rateLimitKey(net.ParseIP("2001:db8:1:2:ffff::2"))
+	if a != b || a != "2001:db8:1:2::" {
+		t.Errorf("want IPv6 clients limited by /64, got %s and %s", a, b)
+	}
+}
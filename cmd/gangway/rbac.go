@@ -0,0 +1,268 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/jcrood/gangway/internal/config"
+	log "github.com/sirupsen/logrus"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// rbacInfo is passed to the RBAC preview template
+type rbacInfo struct {
+	ClusterName string
+	HTTPPath    string
+	Username    string
+	Groups      []string
+	Namespaces  []namespaceRules
+	Check       *accessCheck
+	CSRFToken   string
+}
+
+// namespaceRules are the permissions of the user in a namespace
+type namespaceRules struct {
+	Namespace       string
+	Rules           []resourceVerbs
+	Incomplete      bool
+	EvaluationError string
+}
+
+// resourceVerbs are the verbs permitted on a resource, optionally limited to some names
+type resourceVerbs struct {
+	Resource      string
+	ResourceNames []string
+	Verbs         []string
+}
+
+// accessCheck asks whether the user may perform a single action
+type accessCheck struct {
+	Verb      string
+	Resource  string
+	Namespace string
+	Allowed   bool
+	Reason    string
+}
+
+// clusterIdentity returns the user and groups the API server sees for the credentials gangway
+// hands out, or errCredentialNotAllowed when it hands out none
+func clusterIdentity(data *claimsData) (string, []string, error) {
+	switch cfg.CredentialBackend {
+	case config.CredentialBackendServiceAccount:
+		name, ok := serviceAccountFor(data.Groups)
+		if !ok {
+			return "", nil, fmt.Errorf("%w: no ServiceAccount for the groups %v", errCredentialNotAllowed, data.Groups)
+		}
+		return fmt.Sprintf("system:serviceaccount:%s:%s", cfg.ServiceAccountNamespace, name),
+			[]string{"system:serviceaccounts", "system:serviceaccounts:" + cfg.ServiceAccountNamespace}, nil
+	case config.CredentialBackendCertificate:
+		username, err := certificateUsername(data.Username)
+		if err != nil {
+			return "", nil, err
+		}
+		return username, certificateOrganizations(data.Groups), nil
+	}
+
+	groups := make([]string, 0, len(data.Groups))
+	for _, group := range data.Groups {
+		groups = append(groups, cfg.RBACPreviewGroupsPrefix+group)
+	}
+	return cfg.RBACPreviewUsernamePrefix + data.Username, groups, nil
+}
+
+// previewNamespaces returns the namespaces to review: those of the user, the configured ones and
+// the one asked for, or default if there are none
+func previewNamespaces(userNamespaces []string, requested string) []string {
+	var namespaces []string
+	seen := make(map[string]bool)
+	for _, list := range [][]string{userNamespaces, cfg.RBACPreviewNamespaces, {requested}} {
+		for _, ns := range list {
+			if ns != "" && !seen[ns] {
+				seen[ns] = true
+				namespaces = append(namespaces, ns)
+			}
+		}
+	}
+	if len(namespaces) == 0 {
+		namespaces = []string{"default"}
+	}
+	return namespaces
+}
+
+// reviewRules runs a SelfSubjectRulesReview in every namespace with a client impersonating the user
+func reviewRules(ctx context.Context, client kubernetes.Interface, namespaces []string) ([]namespaceRules, error) {
+	var result []namespaceRules
+	for _, ns := range namespaces {
+		review, err := client.AuthorizationV1().SelfSubjectRulesReviews().Create(ctx, &authorizationv1.SelfSubjectRulesReview{
+			Spec: authorizationv1.SelfSubjectRulesReviewSpec{Namespace: ns},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to review rules in namespace %s: %v", ns, err)
+		}
+		result = append(result, namespaceRules{
+			Namespace:       ns,
+			Rules:           groupResourceRules(review.Status.ResourceRules),
+			Incomplete:      review.Status.Incomplete,
+			EvaluationError: review.Status.EvaluationError,
+		})
+	}
+	return result, nil
+}
+
+// groupResourceRules merges the verbs of rules on the same resource into one row per resource
+func groupResourceRules(rules []authorizationv1.ResourceRule) []resourceVerbs {
+	byResource := make(map[string]*resourceVerbs)
+	verbs := make(map[string]map[string]bool)
+	for _, rule := range rules {
+		for _, group := range rule.APIGroups {
+			for _, resource := range rule.Resources {
+				name := resource
+				if group != "" && !(group == "*" && resource == "*") {
+					name = resource + "." + group
+				}
+				key := name + "/" + strings.Join(rule.ResourceNames, ",")
+				if _, ok := byResource[key]; !ok {
+					byResource[key] = &resourceVerbs{Resource: name, ResourceNames: rule.ResourceNames}
+					verbs[key] = make(map[string]bool)
+				}
+				for _, verb := range rule.Verbs {
+					verbs[key][verb] = true
+				}
+			}
+		}
+	}
+
+	result := make([]resourceVerbs, 0, len(byResource))
+	for key, rv := range byResource {
+		for verb := range verbs[key] {
+			rv.Verbs = append(rv.Verbs, verb)
+		}
+		sort.Strings(rv.Verbs)
+		result = append(result, *rv)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Resource != result[j].Resource {
+			return result[i].Resource < result[j].Resource
+		}
+		return strings.Join(result[i].ResourceNames, ",") < strings.Join(result[j].ResourceNames, ",")
+	})
+	return result
+}
+
+// checkAccess runs a SubjectAccessReview for a single action of the user, with resources given
+// as resource[.group][/subresource], e.g. pods/log or deployments.apps
+func checkAccess(ctx context.Context, client kubernetes.Interface, username string, groups []string, check *accessCheck) error {
+	resource, subresource, _ := strings.Cut(check.Resource, "/")
+	resource, group, _ := strings.Cut(resource, ".")
+
+	review, err := client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   username,
+			Groups: groups,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace:   check.Namespace,
+				Verb:        check.Verb,
+				Group:       group,
+				Resource:    resource,
+				Subresource: subresource,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to review access: %v", err)
+	}
+	check.Allowed = review.Status.Allowed
+	check.Reason = review.Status.Reason
+	if review.Status.EvaluationError != "" {
+		check.Reason = strings.TrimSpace(check.Reason + " " + review.Status.EvaluationError)
+	}
+	return nil
+}
+
+// rbacPreviewHandler shows what the logged in user may do in the cluster
+func rbacPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	info := generateInfo(w, r)
+	if info == nil {
+		// generateInfo writes to the ResponseWriter if it encounters an error.
+		return
+	}
+
+	query := r.URL.Query()
+	requested := query.Get("namespace")
+	if requested != "" && !namespacePattern.MatchString(requested) {
+		http.Error(w, "invalid namespace", http.StatusBadRequest)
+		return
+	}
+
+	username, groups, err := clusterIdentity(newClaimsData(info.Username, info.Claims))
+	if errors.Is(err, errCredentialNotAllowed) {
+		log.Warnf("refused permissions preview from %s: %v", clientAddr(r), err)
+		http.Error(w, "You are not allowed to obtain credentials for this cluster", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Errorf("failed to determine the cluster identity: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	client, err := impersonatedKubeClient(username, groups)
+	if err != nil {
+		log.Errorf("failed to create impersonating client: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	rules, err := reviewRules(r.Context(), client, previewNamespaces(info.Namespaces, requested))
+	if err != nil {
+		log.Errorf("failed to review permissions of %s: %v", username, err)
+		http.Error(w, "Could not review your permissions", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := csrfToken(w, r)
+	if err != nil {
+		log.Errorf("failed to create CSRF token: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	data := &rbacInfo{
+		ClusterName: cfg.ClusterName,
+		HTTPPath:    cfg.HTTPPath,
+		Username:    username,
+		Groups:      groups,
+		Namespaces:  rules,
+		CSRFToken:   token,
+	}
+
+	if verb, resource := query.Get("verb"), query.Get("resource"); verb != "" && resource != "" {
+		data.Check = &accessCheck{Verb: verb, Resource: resource, Namespace: requested}
+		if err := checkAccess(r.Context(), kubeClient, username, groups, data.Check); err != nil {
+			log.Errorf("failed to check access of %s: %v", username, err)
+			http.Error(w, "Could not review your permissions", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	serveTemplate("rbac.tmpl", data, w, r)
+}
@@ -0,0 +1,235 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jcrood/gangway/internal/config"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// fakeAuthorizer answers rules and access reviews from the given rules per namespace
+func fakeAuthorizer(rules map[string][]authorizationv1.ResourceRule) *fake.Clientset {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "selfsubjectrulesreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectRulesReview).DeepCopy()
+		review.Status.ResourceRules = rules[review.Spec.Namespace]
+		review.Status.Incomplete = review.Spec.Namespace == "partial"
+		return true, review, nil
+	})
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview).DeepCopy()
+		attrs := review.Spec.ResourceAttributes
+		for _, rule := range rules[attrs.Namespace] {
+			if contains(rule.Verbs, attrs.Verb) && contains(rule.Resources, attrs.Resource) && contains(rule.APIGroups, attrs.Group) {
+				review.Status.Allowed = true
+				review.Status.Reason = "allowed by test rule"
+			}
+		}
+		return true, review, nil
+	})
+	return client
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value || v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func TestReviewRules(t *testing.T) {
+	client := fakeAuthorizer(map[string][]authorizationv1.ResourceRule{
+		"team-a": {
+			{Verbs: []string{"get", "list"}, APIGroups: []string{""}, Resources: []string{"pods"}},
+			{Verbs: []string{"watch"}, APIGroups: []string{""}, Resources: []string{"pods", "services"}},
+			{Verbs: []string{"*"}, APIGroups: []string{"apps"}, Resources: []string{"deployments"}},
+			{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"configmaps"}, ResourceNames: []string{"settings"}},
+		},
+		"partial": {
+			{Verbs: []string{"*"}, APIGroups: []string{"*"}, Resources: []string{"*"}},
+		},
+	})
+
+	result, err := reviewRules(context.Background(), client, []string{"team-a", "partial", "empty"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []namespaceRules{
+		{
+			Namespace: "team-a",
+			Rules: []resourceVerbs{
+				{Resource: "configmaps", ResourceNames: []string{"settings"}, Verbs: []string{"get"}},
+				{Resource: "deployments.apps", Verbs: []string{"*"}},
+				{Resource: "pods", Verbs: []string{"get", "list", "watch"}},
+				{Resource: "services", Verbs: []string{"watch"}},
+			},
+		},
+		{
+			Namespace:  "partial",
+			Rules:      []resourceVerbs{{Resource: "*", Verbs: []string{"*"}}},
+			Incomplete: true,
+		},
+		{
+			Namespace: "empty",
+			Rules:     []resourceVerbs{},
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("wrong rules:\nwant %+v\ngot  %+v", expected, result)
+	}
+}
+
+func TestCheckAccess(t *testing.T) {
+	client := fakeAuthorizer(map[string][]authorizationv1.ResourceRule{
+		"team-a": {
+			{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}},
+			{Verbs: []string{"list"}, APIGroups: []string{"apps"}, Resources: []string{"deployments"}},
+		},
+	})
+
+	tests := []struct {
+		check   accessCheck
+		allowed bool
+	}{
+		{accessCheck{Verb: "get", Resource: "pods", Namespace: "team-a"}, true},
+		{accessCheck{Verb: "list", Resource: "pods", Namespace: "team-a"}, false},
+		{accessCheck{Verb: "get", Resource: "pods", Namespace: "team-b"}, false},
+		{accessCheck{Verb: "list", Resource: "deployments.apps", Namespace: "team-a"}, true},
+		{accessCheck{Verb: "list", Resource: "deployments", Namespace: "team-a"}, false},
+	}
+
+	for _, tc := range tests {
+		check := tc.check
+		if err := checkAccess(context.Background(), client, "oidc:alice", []string{"oidc:dev"}, &check); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if check.Allowed != tc.allowed {
+			t.Errorf("%s %s in %s: expected allowed=%t", check.Verb, check.Resource, check.Namespace, tc.allowed)
+		}
+	}
+
+	sar := client.Actions()[0].(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+	if sar.Spec.User != "oidc:alice" || !reflect.DeepEqual(sar.Spec.Groups, []string{"oidc:dev"}) {
+		t.Errorf("expected the review for the user, got %+v", sar.Spec)
+	}
+}
+
+func TestClusterIdentity(t *testing.T) {
+	data := &claimsData{Username: "alice", Groups: []string{"dev", "system:masters"}}
+
+	tests := map[string]struct {
+		cfg              *config.Config
+		expectedUsername string
+		expectedGroups   []string
+	}{
+		"oidc with prefixes": {
+			cfg:              &config.Config{CredentialBackend: config.CredentialBackendOIDC, RBACPreviewUsernamePrefix: "oidc:", RBACPreviewGroupsPrefix: "oidc:"},
+			expectedUsername: "oidc:alice",
+			expectedGroups:   []string{"oidc:dev", "oidc:system:masters"},
+		},
+		"certificate": {
+			cfg:              &config.Config{CredentialBackend: config.CredentialBackendCertificate},
+			expectedUsername: "alice",
+			expectedGroups:   []string{"dev"},
+		},
+		"serviceaccount": {
+			cfg:              &config.Config{CredentialBackend: config.CredentialBackendServiceAccount, ServiceAccountNamespace: "users", ServiceAccountGroups: map[string]string{"dev": "developer"}},
+			expectedUsername: "system:serviceaccount:users:developer",
+			expectedGroups:   []string{"system:serviceaccounts", "system:serviceaccounts:users"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg = tc.cfg
+			username, groups, err := clusterIdentity(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if username != tc.expectedUsername || !reflect.DeepEqual(groups, tc.expectedGroups) {
+				t.Errorf("want %s %v, got %s %v", tc.expectedUsername, tc.expectedGroups, username, groups)
+			}
+		})
+	}
+}
+
+func TestClusterIdentityNotAllowed(t *testing.T) {
+	cfg = &config.Config{CredentialBackend: config.CredentialBackendCertificate}
+	for _, username := range []string{"system:kube-controller-manager", "system:node:foo"} {
+		_, _, err := clusterIdentity(&claimsData{Username: username, Groups: []string{"dev"}})
+		if !errors.Is(err, errCredentialNotAllowed) {
+			t.Errorf("%s: expected errCredentialNotAllowed, got %v", username, err)
+		}
+	}
+
+	cfg = &config.Config{CredentialBackend: config.CredentialBackendServiceAccount, ServiceAccountNamespace: "users", ServiceAccountGroups: map[string]string{"dev": "developer"}}
+	if _, _, err := clusterIdentity(&claimsData{Username: "bob", Groups: []string{"ops"}}); !errors.Is(err, errCredentialNotAllowed) {
+		t.Errorf("expected errCredentialNotAllowed without a ServiceAccount, got %v", err)
+	}
+}
+
+func TestPreviewNamespaces(t *testing.T) {
+	cfg = &config.Config{RBACPreviewNamespaces: []string{"shared", "team-a"}}
+	got := previewNamespaces([]string{"team-a"}, "other")
+	if want := []string{"team-a", "shared", "other"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	cfg = &config.Config{}
+	if got := previewNamespaces(nil, ""); !reflect.DeepEqual(got, []string{"default"}) {
+		t.Errorf("expected the default namespace, got %v", got)
+	}
+}
+
+func TestRBACTemplate(t *testing.T) {
+	cfg = &config.Config{}
+	data := &rbacInfo{
+		ClusterName: "prod",
+		Username:    "oidc:alice",
+		Groups:      []string{"oidc:dev"},
+		Namespaces: []namespaceRules{
+			{Namespace: "team-a", Rules: []resourceVerbs{{Resource: "pods", Verbs: []string{"*"}}}},
+			{Namespace: "empty"},
+		},
+		Check: &accessCheck{Verb: "list", Resource: "secrets", Namespace: "team-a", Reason: "<no rule>"},
+	}
+
+	rsp := httptest.NewRecorder()
+	serveTemplate("rbac.tmpl", data, rsp, nil)
+	body := rsp.Body.String()
+	for _, expected := range []string{
+		"<code>pods</code></td>\n            <td>all</td>",
+		"Nothing is allowed in this namespace.",
+		"<strong>Not allowed:</strong>",
+		"&lt;no rule&gt;",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected page to contain %q, got:\n%s", expected, body)
+		}
+	}
+}
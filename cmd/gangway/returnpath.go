@@ -0,0 +1,107 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// returnToKey holds the page to return to after the login in the gangway session
+	returnToKey = "return_to"
+	// defaultReturnPath is the page users land on after the login
+	defaultReturnPath = "/commandline"
+	// maxReturnQuery limits the query kept with a return path, longer ones are dropped
+	maxReturnQuery = 1024
+)
+
+// returnPaths returns the pages a login can return to, relative to the httpPath. Only pages
+// that are fetched with a GET are listed, so the return path cannot be used to redirect
+// users elsewhere or to have them repeat a request.
+func returnPaths() map[string]bool {
+	paths := map[string]bool{
+		"/commandline": true,
+		"/kubeconf":    true,
+	}
+	if cfg.EnableRBACPreview {
+		paths["/rbac"] = true
+	}
+	return paths
+}
+
+// sanitizeReturnPath returns the return path for target, relative to the httpPath, when it
+// is one of the returnPaths. Its query is parsed and encoded again.
+func sanitizeReturnPath(target string) (string, bool) {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil {
+		return "", false
+	}
+	if !returnPaths()[u.Path] {
+		return "", false
+	}
+	if u.RawQuery == "" || len(u.RawQuery) > maxReturnQuery {
+		return u.Path, true
+	}
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return u.Path, true
+	}
+	return u.Path + "?" + query.Encode(), true
+}
+
+// rememberReturnPath keeps the page of r in the gangway session to return to after the
+// login, when it is one of the returnPaths
+func rememberReturnPath(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		return
+	}
+	path := r.URL.Path
+	if cfg.HTTPPath != "" {
+		if !strings.HasPrefix(path, cfg.HTTPPath+"/") {
+			return
+		}
+		path = strings.TrimPrefix(path, cfg.HTTPPath)
+	}
+	target := path
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	returnTo, ok := sanitizeReturnPath(target)
+	if !ok {
+		return
+	}
+
+	// a session that no longer decodes is replaced at login anyway
+	session, _ := gangwayUserSession.Session.Get(r, "gangway")
+	session.Values[returnToKey] = returnTo
+	if err := session.Save(r, w); err != nil {
+		log.Debugf("failed to keep the return path: %v", err)
+	}
+}
+
+// takeReturnPath removes the return path from the session values and returns it, or the
+// defaultReturnPath when there is none
+func takeReturnPath(values map[interface{}]interface{}) string {
+	target, _ := values[returnToKey].(string)
+	delete(values, returnToKey)
+	if returnTo, ok := sanitizeReturnPath(target); ok {
+		return returnTo
+	}
+	return defaultReturnPath
+}
@@ -0,0 +1,112 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jcrood/gangway/internal/config"
+)
+
+func TestSanitizeReturnPath(t *testing.T) {
+	cfg = &config.Config{}
+
+	tests := []struct {
+		target string
+		want   string
+		ok     bool
+	}{
+		{"/commandline", "/commandline", true},
+		{"/kubeconf?format=json", "/kubeconf?format=json", true},
+		{"/kubeconf?format=json&x=%zz", "/kubeconf", true},
+		{"/rbac", "", false},
+		{"/logout", "", false},
+		{"/kubeconf/merge", "", false},
+		{"/commandline/../logout", "", false},
+		{"//evil.example.com/commandline", "", false},
+		{"https://evil.example.com/commandline", "", false},
+		{"/\\evil.example.com", "", false},
+		{"", "", false},
+	}
+
+	for _, tc := range tests {
+		got, ok := sanitizeReturnPath(tc.target)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("%q: want %q %v, got %q %v", tc.target, tc.want, tc.ok, got, ok)
+		}
+	}
+
+	cfg.EnableRBACPreview = true
+	if _, ok := sanitizeReturnPath("/rbac"); !ok {
+		t.Errorf("expected the permissions page with enableRBACPreview")
+	}
+}
+
+func TestLoginRequiredReturnPath(t *testing.T) {
+	cfg = &config.Config{HTTPPath: "/gangway", RedirectURL: "https://gangway.example.com/gangway/callback"}
+	testInit()
+
+	handler := loginRequired(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("expected the request to be redirected to the login")
+	}))
+
+	returnPath := func(method, target string) interface{} {
+		rsp := httptest.NewRecorder()
+		handler.ServeHTTP(rsp, httptest.NewRequest(method, target, nil))
+		if rsp.Code != http.StatusTemporaryRedirect || rsp.Header().Get("Location") != "https://gangway.example.com/gangway" {
+			t.Errorf("%s %s: expected a redirect to the home page, got %d %s", method, target, rsp.Code, rsp.Header().Get("Location"))
+		}
+		req := httptest.NewRequest("GET", "/gangway/callback", nil)
+		for _, c := range rsp.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		session, err := gangwayUserSession.Session.Get(req, "gangway")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return session.Values[returnToKey]
+	}
+
+	if got := returnPath("GET", "/gangway/kubeconf?format=json"); got != "/kubeconf?format=json" {
+		t.Errorf("expected the deep link to be kept, got %v", got)
+	}
+	if got := returnPath("POST", "/gangway/kubeconf"); got != nil {
+		t.Errorf("expected no return path for a POST, got %v", got)
+	}
+	if got := returnPath("GET", "/gangway/logout"); got != nil {
+		t.Errorf("expected no return path for the logout, got %v", got)
+	}
+}
+
+func TestTakeReturnPath(t *testing.T) {
+	cfg = &config.Config{}
+
+	values := map[interface{}]interface{}{returnToKey: "/kubeconf?format=yaml"}
+	if got := takeReturnPath(values); got != "/kubeconf?format=yaml" {
+		t.Errorf("expected the stored return path, got %s", got)
+	}
+	if _, ok := values[returnToKey]; ok {
+		t.Errorf("expected the return path to be removed from the session")
+	}
+	if got := takeReturnPath(values); got != defaultReturnPath {
+		t.Errorf("expected the default return path, got %s", got)
+	}
+	values[returnToKey] = "//evil.example.com"
+	if got := takeReturnPath(values); got != defaultReturnPath {
+		t.Errorf("expected an invalid return path to be ignored, got %s", got)
+	}
+}
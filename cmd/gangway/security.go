@@ -0,0 +1,85 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jcrood/gangway/internal/config"
+	log "github.com/sirupsen/logrus"
+)
+
+type contextKey string
+
+// cspNonceKey holds the Content-Security-Policy nonce of a request
+const cspNonceKey contextKey = "cspNonce"
+
+// newCSPNonce returns a random nonce for the scripts of a single response
+func newCSPNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// requestCSPNonce returns the nonce securityHeaders generated for the request, if any
+func requestCSPNonce(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	nonce, _ := r.Context().Value(cspNonceKey).(string)
+	return nonce
+}
+
+// securityHeaders sets the configured security headers on every response and keeps the
+// responses, which carry tokens or depend on the session, out of caches
+func securityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Cache-Control", "no-store")
+		h.Set("Pragma", "no-cache")
+		if cfg.FrameOptions != "" {
+			h.Set("X-Frame-Options", cfg.FrameOptions)
+		}
+		if cfg.ReferrerPolicy != "" {
+			h.Set("Referrer-Policy", cfg.ReferrerPolicy)
+		}
+		if cfg.HSTSMaxAge.Duration > 0 && cfg.ServesHTTPS() {
+			h.Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d", int64(cfg.HSTSMaxAge.Seconds())))
+		}
+		if cfg.ContentSecurityPolicy != "" {
+			policy := cfg.ContentSecurityPolicy
+			if strings.Contains(policy, config.CSPNoncePlaceholder) {
+				nonce, err := newCSPNonce()
+				if err != nil {
+					log.Errorf("failed to generate CSP nonce: %v", err)
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+				policy = strings.ReplaceAll(policy, config.CSPNoncePlaceholder, nonce)
+				r = r.WithContext(context.WithValue(r.Context(), cspNonceKey, nonce))
+			}
+			h.Set("Content-Security-Policy", policy)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,86 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jcrood/gangway/internal/config"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	cfg = &config.Config{
+		RedirectURL:           "https://gangway.example.com/callback",
+		ContentSecurityPolicy: config.DefaultContentSecurityPolicy,
+		FrameOptions:          "DENY",
+		ReferrerPolicy:        "no-referrer",
+		HSTSMaxAge:            config.Duration{Duration: time.Hour},
+	}
+
+	rsp := httptest.NewRecorder()
+	securityHeaders(http.HandlerFunc(homeHandler)).ServeHTTP(rsp, httptest.NewRequest("GET", "/", nil))
+
+	for header, want := range map[string]string{
+		"X-Content-Type-Options":    "nosniff",
+		"Cache-Control":             "no-store",
+		"X-Frame-Options":           "DENY",
+		"Referrer-Policy":           "no-referrer",
+		"Strict-Transport-Security": "max-age=3600",
+	} {
+		if got := rsp.Header().Get(header); got != want {
+			t.Errorf("%s: want %q, got %q", header, want, got)
+		}
+	}
+
+	policy := rsp.Header().Get("Content-Security-Policy")
+	match := regexp.MustCompile(`'nonce-([A-Za-z0-9_-]+)'`).FindStringSubmatch(policy)
+	if match == nil {
+		t.Fatalf("expected a nonce in the policy, got %q", policy)
+	}
+	if !strings.Contains(rsp.Body.String(), `nonce="`+match[1]+`"`) {
+		t.Errorf("expected the scripts to carry nonce %s, got:\n%s", match[1], rsp.Body.String())
+	}
+
+	// every response gets a nonce of its own
+	again := httptest.NewRecorder()
+	securityHeaders(http.HandlerFunc(homeHandler)).ServeHTTP(again, httptest.NewRequest("GET", "/", nil))
+	if again.Header().Get("Content-Security-Policy") == policy {
+		t.Errorf("expected a fresh nonce, got the same policy twice: %q", policy)
+	}
+}
+
+func TestSecurityHeadersDisabled(t *testing.T) {
+	cfg = &config.Config{
+		RedirectURL: "http://localhost:8080/callback",
+		HSTSMaxAge:  config.Duration{Duration: time.Hour},
+	}
+
+	rsp := httptest.NewRecorder()
+	securityHeaders(http.HandlerFunc(homeHandler)).ServeHTTP(rsp, httptest.NewRequest("GET", "/", nil))
+
+	for _, header := range []string{"Content-Security-Policy", "X-Frame-Options", "Referrer-Policy", "Strict-Transport-Security"} {
+		if got := rsp.Header().Get(header); got != "" {
+			t.Errorf("expected no %s header, got %q", header, got)
+		}
+	}
+	if got := rsp.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("expected responses to stay out of caches, got Cache-Control %q", got)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// serviceAccountFor returns the ServiceAccount mapped to the first of the groups that has one
+func serviceAccountFor(groups []string) (string, bool) {
+	for _, group := range groups {
+		if name, ok := cfg.ServiceAccountGroups[group]; ok && name != "" {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// issueServiceAccountToken requests a token for the ServiceAccount of the user's groups,
+// expiring after the configured TTL, and returns it with its expiry
+func issueServiceAccountToken(ctx context.Context, client kubernetes.Interface, username string, groups []string) (string, time.Time, error) {
+	name, ok := serviceAccountFor(groups)
+	if !ok {
+		return "", time.Time{}, fmt.Errorf("%w: no ServiceAccount for the groups %v", errCredentialNotAllowed, groups)
+	}
+
+	expirationSeconds := int64(cfg.ServiceAccountTokenTTL.Seconds())
+	tokenRequest, err := client.CoreV1().ServiceAccounts(cfg.ServiceAccountNamespace).CreateToken(ctx, name, &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			ExpirationSeconds: &expirationSeconds,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to request token for ServiceAccount %s/%s: %v", cfg.ServiceAccountNamespace, name, err)
+	}
+	if tokenRequest.Status.Token == "" {
+		return "", time.Time{}, fmt.Errorf("no token issued for ServiceAccount %s/%s", cfg.ServiceAccountNamespace, name)
+	}
+
+	// the cluster only sees the ServiceAccount, so this is the record of who got its token
+	log.Infof("issued token for ServiceAccount %s/%s to %s, expiring %s", cfg.ServiceAccountNamespace, name, username, tokenRequest.Status.ExpirationTimestamp.UTC().Format("2006-01-02T15:04:05Z"))
+	return tokenRequest.Status.Token, tokenRequest.Status.ExpirationTimestamp.UTC(), nil
+}
@@ -0,0 +1,112 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jcrood/gangway/internal/config"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestIssueServiceAccountToken(t *testing.T) {
+	cfg = &config.Config{
+		ServiceAccountNamespace: "gangway-users",
+		ServiceAccountGroups:    map[string]string{"ops": "ops-admin", "dev": "developer"},
+		ServiceAccountTokenTTL:  config.Duration{Duration: 30 * time.Minute},
+	}
+
+	tests := map[string]struct {
+		groups          []string
+		expectedAccount string
+		expectError     error
+	}{
+		"first mapped group wins": {
+			groups:          []string{"staff", "dev", "ops"},
+			expectedAccount: "developer",
+		},
+		"no mapped group": {
+			groups:      []string{"staff"},
+			expectError: errCredentialNotAllowed,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var requested *authenticationv1.TokenRequest
+			var account, namespace string
+			client := fake.NewSimpleClientset()
+			client.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if action.GetSubresource() != "token" {
+					return false, nil, nil
+				}
+				create := action.(k8stesting.CreateActionImpl)
+				requested = create.GetObject().(*authenticationv1.TokenRequest)
+				account, namespace = create.Name, create.GetNamespace()
+				return true, &authenticationv1.TokenRequest{
+					Status: authenticationv1.TokenRequestStatus{
+						Token:               "token-" + account,
+						ExpirationTimestamp: metav1.NewTime(time.Now().Add(30 * time.Minute)),
+					},
+				}, nil
+			})
+
+			token, expiry, err := issueServiceAccountToken(context.Background(), client, "alice", tc.groups)
+			if tc.expectError != nil {
+				if !errors.Is(err, tc.expectError) {
+					t.Errorf("expected error %v, got %v", tc.expectError, err)
+				}
+				if len(client.Actions()) != 0 {
+					t.Errorf("expected no requests to the cluster, got %v", client.Actions())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if account != tc.expectedAccount || namespace != "gangway-users" {
+				t.Errorf("wrong ServiceAccount: want gangway-users/%s, got %s/%s", tc.expectedAccount, namespace, account)
+			}
+			if token != "token-"+tc.expectedAccount {
+				t.Errorf("wrong token %s", token)
+			}
+			if until := time.Until(expiry); until < 29*time.Minute || until > 30*time.Minute {
+				t.Errorf("wrong expiry %v", expiry)
+			}
+			if requested.Spec.ExpirationSeconds == nil || *requested.Spec.ExpirationSeconds != 1800 {
+				t.Errorf("expected a bounded expiry of 1800 seconds, got %v", requested.Spec.ExpirationSeconds)
+			}
+		})
+	}
+}
+
+func TestKubeConfigAuthInfoBearerToken(t *testing.T) {
+	info := &userInfo{BearerToken: "token", ClientID: "gangway"}
+	authInfo := kubeConfigAuthInfo(info)
+	if authInfo.Token != "token" {
+		t.Errorf("expected the bearer token, got %+v", authInfo)
+	}
+	if authInfo.AuthProvider != nil {
+		t.Errorf("expected no auth provider, got %+v", authInfo.AuthProvider)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// externalURL returns the absolute URL of a gangway path, as reachable by users. The scheme
+// and host are those the client used when they can be trusted, or else those of the
+// configured redirectURL.
+func externalURL(r *http.Request, path string) string {
+	p := cfg.HTTPPath + path
+	if p == "" {
+		p = "/"
+	}
+
+	base, ok := externalBase(r)
+	if !ok {
+		u, err := url.Parse(cfg.RedirectURL)
+		if err != nil || u.Host == "" {
+			return p
+		}
+		base = url.URL{Scheme: u.Scheme, Host: u.Host}
+	}
+	return strings.TrimRight(base.String(), "/") + p
+}
+
+// redirectURI returns the redirect URI to send to the identity provider for a login started
+// with r: the redirectURL, moved to the scheme and host of one of the externalURLs when the
+// client used that
+func redirectURI(r *http.Request) string {
+	u, err := url.Parse(cfg.RedirectURL)
+	if err != nil || len(cfg.ExternalURLs) == 0 {
+		return cfg.RedirectURL
+	}
+	if base, ok := externalBase(r); ok {
+		u.Scheme, u.Host = base.Scheme, base.Host
+	}
+	return u.String()
+}
+
+// externalBase returns the scheme and host the client used. With externalURLs configured
+// these are the host of the request or the one passed on by a trusted proxy, as long as
+// they are either redirectURL's or listed in externalURLs. Without, only a host passed on
+// by a trusted proxy is used.
+func externalBase(r *http.Request) (url.URL, bool) {
+	if r == nil {
+		return url.URL{}, false
+	}
+	base, ok := forwardedBase(r)
+	if len(cfg.ExternalURLs) == 0 {
+		return base, ok
+	}
+
+	if !ok {
+		base = url.URL{Scheme: "http", Host: r.Host}
+		if r.TLS != nil {
+			base.Scheme = "https"
+		}
+	}
+	base.Host = strings.ToLower(base.Host)
+	if !allowedExternalBase(base) {
+		log.Debugf("ignoring %s, which is not one of the externalURLs", base.String())
+		return url.URL{}, false
+	}
+	return base, true
+}
+
+// allowedExternalBase returns whether base is the scheme and host of redirectURL or one of
+// the externalURLs
+func allowedExternalBase(base url.URL) bool {
+	allowed, err := cfg.ExternalBaseURLs()
+	if err != nil {
+		log.Errorf("invalid externalURLs: %v", err)
+		return false
+	}
+	if u, err := url.Parse(cfg.RedirectURL); err == nil {
+		allowed = append(allowed, url.URL{Scheme: u.Scheme, Host: strings.ToLower(u.Host)})
+	}
+	for _, a := range allowed {
+		if a.Scheme == base.Scheme && a.Host == base.Host {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedBase returns the scheme and host a trusted proxy received the request with
+func forwardedBase(r *http.Request) (url.URL, bool) {
+	o := requestOrigin(r)
+	if o.proto != "http" && o.proto != "https" || !validHost(o.host) {
+		return url.URL{}, false
+	}
+	return url.URL{Scheme: o.proto, Host: o.host}, true
+}
+
+// validHost returns whether host is a plain host name or address with an optional port,
+// so it cannot change the path or credentials of a URL it is put in
+func validHost(host string) bool {
+	if host == "" {
+		return false
+	}
+	u, err := url.Parse("//" + host)
+	return err == nil && u.Host == host && u.User == nil && u.Path == "" && !strings.ContainsAny(host, `\ `)
+}
@@ -0,0 +1,145 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/jcrood/gangway/internal/config"
+)
+
+// forwarded returns a request passed on by a proxy at remoteAddr
+func forwarded(remoteAddr, proto, host string) *http.Request {
+	r := httptest.NewRequest("GET", "/gangway/commandline", nil)
+	r.RemoteAddr = remoteAddr
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	r.Header.Set("X-Forwarded-Proto", proto)
+	r.Header.Set("X-Forwarded-Host", host)
+	return r
+}
+
+func TestExternalURL(t *testing.T) {
+	cfg = &config.Config{
+		RedirectURL:    "https://gangway.example.com/gangway/callback",
+		HTTPPath:       "/gangway",
+		TrustedProxies: []string{"10.0.0.0/8"},
+	}
+
+	tests := []struct {
+		name string
+		r    *http.Request
+		path string
+		want string
+	}{
+		{"no request", nil, "/dl/x", "https://gangway.example.com/gangway/dl/x"},
+		{"root", nil, "", "https://gangway.example.com/gangway"},
+		{"trusted proxy", forwarded("10.0.0.1:1234", "https", "k8s.example.org:8443"), "/commandline", "https://k8s.example.org:8443/gangway/commandline"},
+		{"untrusted proxy", forwarded("192.0.2.1:1234", "https", "evil.example.com"), "/commandline", "https://gangway.example.com/gangway/commandline"},
+		{"host with a path", forwarded("10.0.0.1:1234", "https", "evil.example.com/x"), "", "https://gangway.example.com/gangway"},
+		{"host with credentials", forwarded("10.0.0.1:1234", "https", "user@evil.example.com"), "", "https://gangway.example.com/gangway"},
+		{"unknown scheme", forwarded("10.0.0.1:1234", "javascript", "k8s.example.org"), "", "https://gangway.example.com/gangway"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := externalURL(tc.r, tc.path); got != tc.want {
+				t.Errorf("want %s, got %s", tc.want, got)
+			}
+		})
+	}
+
+	cfg = &config.Config{RedirectURL: "https://gangway.example.com/callback"}
+	if got := externalURL(nil, ""); got != "https://gangway.example.com/" {
+		t.Errorf("want the root path without httpPath, got %s", got)
+	}
+}
+
+func TestRedirectURI(t *testing.T) {
+	cfg = &config.Config{
+		RedirectURL:    "https://gangway.example.com/gangway/callback",
+		HTTPPath:       "/gangway",
+		TrustedProxies: []string{"10.0.0.0/8"},
+	}
+
+	request := func(host string, tls bool) *http.Request {
+		target := "http://" + host + "/gangway/login"
+		if tls {
+			target = "https://" + host + "/gangway/login"
+		}
+		return httptest.NewRequest("GET", target, nil)
+	}
+
+	// without externalURLs the redirectURL is used as it is
+	if got := redirectURI(request("gangway.internal", false)); got != cfg.RedirectURL {
+		t.Errorf("want the redirectURL, got %s", got)
+	}
+
+	cfg.ExternalURLs = []string{"http://gangway.internal", "https://k8s.example.org"}
+	tests := []struct {
+		name string
+		r    *http.Request
+		want string
+	}{
+		{"redirectURL host", request("gangway.example.com", true), "https://gangway.example.com/gangway/callback"},
+		{"external URL", request("gangway.internal", false), "http://gangway.internal/gangway/callback"},
+		{"external URL with another scheme", request("gangway.internal", true), cfg.RedirectURL},
+		{"unknown host", request("evil.example.com", true), cfg.RedirectURL},
+		{"trusted proxy", forwarded("10.0.0.1:1234", "https", "K8S.example.org"), "https://k8s.example.org/gangway/callback"},
+		{"trusted proxy with unknown host", forwarded("10.0.0.1:1234", "https", "evil.example.com"), cfg.RedirectURL},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := redirectURI(tc.r); got != tc.want {
+				t.Errorf("want %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestLoginHandlerRedirectURI(t *testing.T) {
+	cfg = &config.Config{
+		RedirectURL:  "https://gangway.example.com/callback",
+		ExternalURLs: []string{"http://gangway.internal"},
+	}
+	testInit()
+
+	rsp := httptest.NewRecorder()
+	loginHandler(rsp, httptest.NewRequest("GET", "http://gangway.internal/login", nil))
+	location, err := url.Parse(rsp.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "http://gangway.internal/callback"
+	if got := location.Query().Get("redirect_uri"); got != want {
+		t.Errorf("want redirect_uri %s, got %s", want, got)
+	}
+
+	// the callback exchanges the code with the same redirect URI
+	req := httptest.NewRequest("GET", "/callback", nil)
+	for _, c := range rsp.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	session, err := gangwayUserSession.Session.Get(req, "gangway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := session.Values["redirect_uri"]; got != want {
+		t.Errorf("want %s kept in the session, got %v", want, got)
+	}
+}
@@ -17,7 +17,11 @@ package config
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/url"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 	"sigs.k8s.io/yaml"
@@ -25,6 +29,55 @@ import (
 
 const hardCodedDefaultSalt = "MkmfuPNHnZBBivy0L0aW"
 
+// Snippet modes for the commands shown on the commandline page
+const (
+	// SnippetModeInline shows commands with the credentials inlined
+	SnippetModeInline = "inline"
+	// SnippetModeBootstrap shows commands fetching a script through a one-time link
+	SnippetModeBootstrap = "bootstrap"
+)
+
+// Credential backends providing the credentials in generated kubeconfigs
+const (
+	// CredentialBackendOIDC hands out the tokens of the OIDC login
+	CredentialBackendOIDC = "oidc"
+	// CredentialBackendCertificate hands out client certificates issued through the CSR API
+	CredentialBackendCertificate = "certificate"
+	// CredentialBackendServiceAccount hands out ServiceAccount tokens issued through the TokenRequest API
+	CredentialBackendServiceAccount = "serviceaccount"
+)
+
+// Token types that can be requested in a token exchange
+const (
+	TokenTypeIDToken     = "id_token"
+	TokenTypeAccessToken = "access_token"
+)
+
+// SameSite policies of the session cookies
+const (
+	CookieSameSiteLax    = "lax"
+	CookieSameSiteStrict = "strict"
+	CookieSameSiteNone   = "none"
+)
+
+// CSPNoncePlaceholder is replaced by a fresh nonce for every response in contentSecurityPolicy
+const CSPNoncePlaceholder = "{nonce}"
+
+// DefaultContentSecurityPolicy only allows the assets served by gangway and scripts carrying the nonce
+const DefaultContentSecurityPolicy = "default-src 'self'; script-src 'self' 'nonce-" + CSPNoncePlaceholder + "'; " +
+	"style-src 'self'; img-src 'self' data:; object-src 'none'; base-uri 'self'; frame-ancestors 'none'"
+
+// MaxCookieMaxSize is the largest session the session store encodes, and the default of
+// cookieMaxSize so sessions that fit before the option existed keep working
+const MaxCookieMaxSize = 81920
+
+// Default naming patterns for the entries in generated kubeconfigs
+const (
+	DefaultKubeconfigClusterName = "{{ .ClusterName }}"
+	DefaultKubeconfigContextName = "{{ .ClusterName }}"
+	DefaultKubeconfigUserName    = "{{ .Username }}@{{ .ClusterName }}"
+)
+
 // Config the configuration field for gangway
 type Config struct {
 	Host string `yaml:"host"`
@@ -40,6 +93,7 @@ type Config struct {
 	Scopes                 []string `yaml:"scopes" envconfig:"scopes"`
 	UsernameClaim          string   `yaml:"usernameClaim" envconfig:"username_claim"`
 	EmailClaim             string   `yaml:"emailClaim" envconfig:"email_claim"`
+	GroupsClaim            string   `yaml:"groupsClaim" envconfig:"groups_claim"`
 	ServeTLS               bool     `yaml:"serveTLS" envconfig:"serve_tls"`
 	CertFile               string   `yaml:"certFile" envconfig:"cert_file"`
 	KeyFile                string   `yaml:"keyFile" envconfig:"key_file"`
@@ -48,12 +102,72 @@ type Config struct {
 	ClusterCA              []byte
 	TrustedCAPath          string `yaml:"trustedCAPath" envconfig:"trusted_ca_path"`
 	TrustedCA              []byte
-	HTTPPath               string `yaml:"httpPath" envconfig:"http_path"`
-	ShowClaims             bool   `yaml:"showClaims" envconfig:"show_claims"`
-	SessionSecurityKey     string `yaml:"sessionSecurityKey" envconfig:"session_security_key"`
-	SessionSalt            string `yaml:"sessionSalt" envconfig:"session_salt"`
-	CustomHTMLTemplatesDir string `yaml:"customHTMLTemplatesDir" envconfig:"custom_html_templates_dir"`
-	CustomAssetsDir        string `yaml:"customAssetsDir" envconfig:"custom_assets_dir"`
+	HTTPPath               string   `yaml:"httpPath" envconfig:"http_path"`
+	ShowClaims             bool     `yaml:"showClaims" envconfig:"show_claims"`
+	RedactClaims           []string `yaml:"redactClaims" envconfig:"redact_claims"`
+	SessionSecurityKey     string   `yaml:"sessionSecurityKey" envconfig:"session_security_key"`
+	SessionSalt            string   `yaml:"sessionSalt" envconfig:"session_salt"`
+	CustomHTMLTemplatesDir string   `yaml:"customHTMLTemplatesDir" envconfig:"custom_html_templates_dir"`
+	CustomAssetsDir        string   `yaml:"customAssetsDir" envconfig:"custom_assets_dir"`
+
+	// PreviousSessionSecurityKeys still decode the cookies issued before a key rotation
+	PreviousSessionSecurityKeys []string `yaml:"previousSessionSecurityKeys" envconfig:"previous_session_security_keys"`
+
+	NamespaceTemplate   string            `yaml:"namespaceTemplate" envconfig:"namespace_template"`
+	GroupNamespaces     map[string]string `yaml:"groupNamespaces" envconfig:"group_namespaces"`
+	ContextPerNamespace bool              `yaml:"contextPerNamespace" envconfig:"context_per_namespace"`
+
+	KubeconfigClusterName string `yaml:"kubeconfigClusterName" envconfig:"kubeconfig_cluster_name"`
+	KubeconfigContextName string `yaml:"kubeconfigContextName" envconfig:"kubeconfig_context_name"`
+	KubeconfigUserName    string `yaml:"kubeconfigUserName" envconfig:"kubeconfig_user_name"`
+
+	EnableDownloadLinks bool     `yaml:"enableDownloadLinks" envconfig:"enable_download_links"`
+	DownloadLinkTTL     Duration `yaml:"downloadLinkTTL" envconfig:"download_link_ttl"`
+	SnippetMode         string   `yaml:"snippetMode" envconfig:"snippet_mode"`
+
+	PublicClientID         string `yaml:"publicClientID" envconfig:"public_client_id"`
+	PublicClientExecPlugin bool   `yaml:"publicClientExecPlugin" envconfig:"public_client_exec_plugin"`
+
+	TokenExchangeAudience  string `yaml:"tokenExchangeAudience" envconfig:"token_exchange_audience"`
+	TokenExchangeTokenType string `yaml:"tokenExchangeTokenType" envconfig:"token_exchange_token_type"`
+
+	CredentialBackend        string   `yaml:"credentialBackend" envconfig:"credential_backend"`
+	ClusterKubeconfigPath    string   `yaml:"clusterKubeconfigPath" envconfig:"cluster_kubeconfig_path"`
+	CertificateSignerName    string   `yaml:"certificateSignerName" envconfig:"certificate_signer_name"`
+	CertificateTTL           Duration `yaml:"certificateTTL" envconfig:"certificate_ttl"`
+	CertificateApproveGroups []string `yaml:"certificateApproveGroups" envconfig:"certificate_approve_groups"`
+
+	ServiceAccountNamespace string            `yaml:"serviceAccountNamespace" envconfig:"service_account_namespace"`
+	ServiceAccountGroups    map[string]string `yaml:"serviceAccountGroups" envconfig:"service_account_groups"`
+	ServiceAccountTokenTTL  Duration          `yaml:"serviceAccountTokenTTL" envconfig:"service_account_token_ttl"`
+
+	EnableRBACPreview         bool     `yaml:"enableRBACPreview" envconfig:"enable_rbac_preview"`
+	RBACPreviewNamespaces     []string `yaml:"rbacPreviewNamespaces" envconfig:"rbac_preview_namespaces"`
+	RBACPreviewUsernamePrefix string   `yaml:"rbacPreviewUsernamePrefix" envconfig:"rbac_preview_username_prefix"`
+	RBACPreviewGroupsPrefix   string   `yaml:"rbacPreviewGroupsPrefix" envconfig:"rbac_preview_groups_prefix"`
+
+	ContentSecurityPolicy string   `yaml:"contentSecurityPolicy" envconfig:"content_security_policy"`
+	FrameOptions          string   `yaml:"frameOptions" envconfig:"frame_options"`
+	ReferrerPolicy        string   `yaml:"referrerPolicy" envconfig:"referrer_policy"`
+	HSTSMaxAge            Duration `yaml:"hstsMaxAge" envconfig:"hsts_max_age"`
+
+	// CookieSecure is derived from redirectURL and serveTLS when not set
+	CookieSecure   *bool    `yaml:"cookieSecure" envconfig:"cookie_secure"`
+	CookieSameSite string   `yaml:"cookieSameSite" envconfig:"cookie_same_site"`
+	CookieDomain   string   `yaml:"cookieDomain" envconfig:"cookie_domain"`
+	CookiePath     string   `yaml:"cookiePath" envconfig:"cookie_path"`
+	CookieMaxAge   Duration `yaml:"cookieMaxAge" envconfig:"cookie_max_age"`
+	CookieMaxSize  int      `yaml:"cookieMaxSize" envconfig:"cookie_max_size"`
+	CookieCompress bool     `yaml:"cookieCompress" envconfig:"cookie_compress"`
+
+	RateLimit      int      `yaml:"rateLimit" envconfig:"rate_limit"`
+	RateLimitBurst int      `yaml:"rateLimitBurst" envconfig:"rate_limit_burst"`
+	TrustedProxies []string `yaml:"trustedProxies" envconfig:"trusted_proxies"`
+
+	// ExternalURLs are the other base URLs users reach gangway with besides the one of redirectURL
+	ExternalURLs []string `yaml:"externalURLs" envconfig:"external_urls"`
+
+	EnableMetrics bool `yaml:"enableMetrics" envconfig:"enable_metrics"`
 }
 
 // NewConfig returns a Config struct from serialized config file
@@ -66,6 +180,7 @@ func NewConfig(configFile string) (*Config, error) {
 		Scopes:                 []string{"openid", "profile", "email", "offline_access"},
 		UsernameClaim:          "nickname",
 		EmailClaim:             "",
+		GroupsClaim:            "groups",
 		ServeTLS:               false,
 		CertFile:               "/etc/gangway/tls/tls.crt",
 		KeyFile:                "/etc/gangway/tls/tls.key",
@@ -73,6 +188,24 @@ func NewConfig(configFile string) (*Config, error) {
 		HTTPPath:               "",
 		ShowClaims:             false,
 		SessionSalt:            hardCodedDefaultSalt,
+		KubeconfigClusterName:  DefaultKubeconfigClusterName,
+		KubeconfigContextName:  DefaultKubeconfigContextName,
+		KubeconfigUserName:     DefaultKubeconfigUserName,
+		DownloadLinkTTL:        Duration{5 * time.Minute},
+		SnippetMode:            SnippetModeInline,
+		TokenExchangeTokenType: TokenTypeIDToken,
+		CredentialBackend:      CredentialBackendOIDC,
+		CertificateSignerName:  "kubernetes.io/kube-apiserver-client",
+		CertificateTTL:         Duration{time.Hour},
+		ServiceAccountTokenTTL: Duration{time.Hour},
+		ContentSecurityPolicy:  DefaultContentSecurityPolicy,
+		FrameOptions:           "DENY",
+		ReferrerPolicy:         "no-referrer",
+		HSTSMaxAge:             Duration{365 * 24 * time.Hour},
+		CookieSameSite:         CookieSameSiteLax,
+		CookieMaxAge:           Duration{30 * 24 * time.Hour},
+		CookieMaxSize:          MaxCookieMaxSize,
+		RateLimitBurst:         10,
 	}
 
 	if configFile != "" {
@@ -119,8 +252,33 @@ func (cfg *Config) Validate() error {
 		{cfg.ClientSecret == "" && !cfg.AllowEmptyClientSecret, "no clientSecret specified"},
 		{cfg.RedirectURL == "", "no redirectURL specified"},
 		{cfg.SessionSecurityKey == "", "no SessionSecurityKey specified"},
+		{containsEmpty(cfg.PreviousSessionSecurityKeys), "previousSessionSecurityKeys cannot contain empty keys"},
 		{cfg.APIServerURL == "", "no apiServerURL specified"},
 		{len(cfg.SessionSalt) < 8, "salt needs to be min. 8 characters"},
+		{cfg.UseDownloadLinks() && cfg.DownloadLinkTTL.Duration <= 0, "downloadLinkTTL needs to be positive"},
+		{cfg.SnippetMode != SnippetModeInline && cfg.SnippetMode != SnippetModeBootstrap, "snippetMode must be inline or bootstrap"},
+		{cfg.PublicClientExecPlugin && cfg.PublicClientID == "", "publicClientExecPlugin requires a publicClientID"},
+		// refresh tokens are bound to clientID, kubectl cannot use them with another client
+		{cfg.PublicClientID != "" && !cfg.PublicClientExecPlugin, "publicClientID requires publicClientExecPlugin"},
+		{cfg.TokenExchangeAudience != "" && cfg.TokenExchangeTokenType != TokenTypeIDToken && cfg.TokenExchangeTokenType != TokenTypeAccessToken, "tokenExchangeTokenType must be id_token or access_token"},
+		{cfg.TokenExchangeAudience != "" && cfg.PublicClientExecPlugin, "tokenExchangeAudience cannot be used with publicClientExecPlugin"},
+		{cfg.CredentialBackend != CredentialBackendOIDC && cfg.CredentialBackend != CredentialBackendCertificate && cfg.CredentialBackend != CredentialBackendServiceAccount, "credentialBackend must be oidc, certificate or serviceaccount"},
+		{cfg.CredentialBackend != CredentialBackendOIDC && (cfg.PublicClientExecPlugin || cfg.TokenExchangeAudience != ""), "publicClientExecPlugin and tokenExchangeAudience require the oidc credentialBackend"},
+		{cfg.CredentialBackend == CredentialBackendCertificate && cfg.CertificateSignerName == "", "no certificateSignerName specified"},
+		{cfg.CredentialBackend == CredentialBackendCertificate && cfg.CertificateTTL.Duration < 10*time.Minute, "certificateTTL needs to be at least 10m"},
+		{cfg.CredentialBackend == CredentialBackendCertificate && len(cfg.CertificateApproveGroups) == 0, "no certificateApproveGroups specified"},
+		{cfg.CredentialBackend == CredentialBackendServiceAccount && cfg.ServiceAccountNamespace == "", "no serviceAccountNamespace specified"},
+		{cfg.CredentialBackend == CredentialBackendServiceAccount && len(cfg.ServiceAccountGroups) == 0, "no serviceAccountGroups specified"},
+		{cfg.CredentialBackend == CredentialBackendServiceAccount && cfg.ServiceAccountTokenTTL.Duration < 10*time.Minute, "serviceAccountTokenTTL needs to be at least 10m"},
+		{cfg.HSTSMaxAge.Duration < 0, "hstsMaxAge cannot be negative"},
+		{strings.ContainsAny(cfg.ContentSecurityPolicy+cfg.FrameOptions+cfg.ReferrerPolicy, "\r\n"), "security headers cannot contain line breaks"},
+		{cfg.CookieSameSite != CookieSameSiteLax && cfg.CookieSameSite != CookieSameSiteStrict && cfg.CookieSameSite != CookieSameSiteNone, "cookieSameSite must be lax, strict or none"},
+		{cfg.CookieSameSite == CookieSameSiteNone && !cfg.SecureCookies(), "cookieSameSite none requires secure cookies"},
+		{cfg.CookieMaxAge.Duration <= 0, "cookieMaxAge needs to be positive"},
+		{cfg.CookieMaxSize < 4096 || cfg.CookieMaxSize > MaxCookieMaxSize, "cookieMaxSize needs to be between 4096 and 81920"},
+		{cfg.CookiePath != "" && !strings.HasPrefix(cfg.CookiePath, "/"), "cookiePath must start with /"},
+		{cfg.RateLimit < 0, "rateLimit cannot be negative"},
+		{cfg.RateLimit > 0 && cfg.RateLimitBurst < 1, "rateLimitBurst needs to be at least 1"},
 	}
 
 	for _, check := range checks {
@@ -128,6 +286,29 @@ func (cfg *Config) Validate() error {
 			return fmt.Errorf("invalid config: %s", check.errMsg)
 		}
 	}
+
+	templates := []struct {
+		name string
+		text string
+	}{
+		{"namespaceTemplate", cfg.NamespaceTemplate},
+		{"kubeconfigClusterName", cfg.KubeconfigClusterName},
+		{"kubeconfigContextName", cfg.KubeconfigContextName},
+		{"kubeconfigUserName", cfg.KubeconfigUserName},
+	}
+
+	for _, t := range templates {
+		if _, err := template.New(t.name).Parse(t.text); err != nil {
+			return fmt.Errorf("invalid config: %s: %s", t.name, err)
+		}
+	}
+
+	if _, err := cfg.TrustedProxyNets(); err != nil {
+		return fmt.Errorf("invalid config: trustedProxies: %s", err)
+	}
+	if _, err := cfg.ExternalBaseURLs(); err != nil {
+		return fmt.Errorf("invalid config: externalURLs: %s", err)
+	}
 	return nil
 }
 
@@ -140,6 +321,130 @@ func (cfg *Config) GetRootPathPrefix() string {
 	return strings.TrimRight(cfg.HTTPPath, "/")
 }
 
+// ServesHTTPS returns whether users reach gangway over HTTPS, either served by gangway
+// itself or by a proxy in front of it
+func (cfg *Config) ServesHTTPS() bool {
+	return cfg.ServeTLS || strings.HasPrefix(strings.ToLower(cfg.RedirectURL), "https://")
+}
+
+// SessionSecurityKeys returns the keys of the session cookies, the current key first
+func (cfg *Config) SessionSecurityKeys() []string {
+	return append([]string{cfg.SessionSecurityKey}, cfg.PreviousSessionSecurityKeys...)
+}
+
+// SecureCookies returns whether the session cookies are only sent over HTTPS, by default
+// when users reach gangway over HTTPS
+func (cfg *Config) SecureCookies() bool {
+	if cfg.CookieSecure != nil {
+		return *cfg.CookieSecure
+	}
+	return cfg.ServesHTTPS()
+}
+
+// SessionCookiePath returns the path of the session cookies, by default the httpPath
+func (cfg *Config) SessionCookiePath() string {
+	if cfg.CookiePath != "" {
+		return cfg.CookiePath
+	}
+	return cfg.GetRootPathPrefix()
+}
+
+// TrustedProxyNets returns the networks of the trustedProxies, single addresses as a
+// network of their own
+func (cfg *Config) TrustedProxyNets() ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, proxy := range cfg.TrustedProxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %s", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// ExternalBaseURLs returns the scheme and host of the externalURLs
+func (cfg *Config) ExternalBaseURLs() ([]url.URL, error) {
+	var bases []url.URL
+	for _, external := range cfg.ExternalURLs {
+		u, err := url.Parse(external)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("not an absolute http or https URL: %s", external)
+		}
+		if strings.TrimRight(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+			return nil, fmt.Errorf("only the scheme and host can be given, the httpPath is added: %s", external)
+		}
+		bases = append(bases, url.URL{Scheme: u.Scheme, Host: strings.ToLower(u.Host)})
+	}
+	return bases, nil
+}
+
+// UseDownloadLinks returns whether one-time download links are issued, either to
+// download kubeconfigs or to fetch bootstrap scripts
+func (cfg *Config) UseDownloadLinks() bool {
+	return cfg.EnableDownloadLinks || cfg.SnippetMode == SnippetModeBootstrap
+}
+
+// Warnings returns the settings that are valid, but likely not what was intended
+func (cfg *Config) Warnings() []string {
+	var warnings []string
+	if cfg.ClientSecret == "" {
+		warnings = append(warnings, "Setting an empty Client Secret should only be done if you have no other option and is an inherent security risk.")
+	}
+	if cfg.PublicClientID != "" && cfg.PublicClientID == cfg.ClientID {
+		warnings = append(warnings, "publicClientID is the same as clientID: the client secret is no longer handed out, but anyone holding a kubeconfig can still act as gangway's client. Register a separate public client.")
+	}
+	if cfg.CookieSameSite == CookieSameSiteStrict {
+		warnings = append(warnings, "cookieSameSite strict keeps browsers from sending the session cookies when the identity provider redirects back, logins will fail unless both share a site.")
+	}
+	if cfg.ServesHTTPS() && !cfg.SecureCookies() {
+		warnings = append(warnings, "cookieSecure is disabled although gangway is reached over HTTPS, session cookies holding tokens may be sent over plain HTTP.")
+	}
+	if cfg.RateLimit > 0 && len(cfg.TrustedProxies) == 0 && !cfg.ServeTLS {
+		warnings = append(warnings, "rateLimit is set without trustedProxies: behind a proxy or load balancer all users share the limit of its address and logins will be refused.")
+	}
+	for _, key := range cfg.PreviousSessionSecurityKeys {
+		if key == cfg.SessionSecurityKey {
+			warnings = append(warnings, "previousSessionSecurityKeys contains the current sessionSecurityKey, remove it once the rotation is done.")
+			break
+		}
+	}
+	return warnings
+}
+
+// KubeconfigClientID returns the client ID to put in generated kubeconfigs
+func (cfg *Config) KubeconfigClientID() string {
+	if cfg.PublicClientID != "" {
+		return cfg.PublicClientID
+	}
+	return cfg.ClientID
+}
+
+// KubeconfigClientSecret returns the client secret to put in generated kubeconfigs,
+// which is empty when a public client is configured. Validate only allows a public client
+// when the kubeconfig holds no refresh token of gangway's client.
+func (cfg *Config) KubeconfigClientSecret() string {
+	if cfg.PublicClientID != "" {
+		return ""
+	}
+	return cfg.ClientSecret
+}
+
 func (cfg *Config) loadCerts() error {
 	if cfg.ClusterCAPath != "" {
 		clusterCA, err := ioutil.ReadFile(cfg.ClusterCAPath)
@@ -159,3 +464,13 @@ func (cfg *Config) loadCerts() error {
 
 	return nil
 }
+
+// containsEmpty returns whether any of the values is empty
+func containsEmpty(values []string) bool {
+	for _, v := range values {
+		if v == "" {
+			return true
+		}
+	}
+	return false
+}
@@ -15,8 +15,12 @@
 package config
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestConfigNotFound(t *testing.T) {
@@ -66,6 +70,9 @@ func TestEnvionmentOverrides(t *testing.T) {
 	if cfg.SessionSalt != salt {
 		t.Errorf("Failed to override session salt. Expected %s but got %s", salt, cfg.SessionSalt)
 	}
+	if cfg.CookieMaxSize != MaxCookieMaxSize {
+		t.Errorf("Expected the sessions allowed before cookieMaxSize by default, got %d", cfg.CookieMaxSize)
+	}
 }
 
 func TestSessionSaltLength(t *testing.T) {
@@ -118,3 +125,393 @@ func TestGetRootPathPrefix(t *testing.T) {
 		})
 	}
 }
+
+func validConfig() *Config {
+	return &Config{
+		ProviderURL:        "https://foo.bar",
+		ClientID:           "foo",
+		ClientSecret:       "bar",
+		RedirectURL:        "https://foo.baz/callback",
+		SessionSecurityKey: "testing",
+		APIServerURL:       "https://k8s-api.foo.baz",
+		SessionSalt:        "0123456789",
+		SnippetMode:        SnippetModeInline,
+		CredentialBackend:  CredentialBackendOIDC,
+		CookieSameSite:     CookieSameSiteLax,
+		CookieMaxAge:       Duration{time.Hour},
+		CookieMaxSize:      16384,
+	}
+}
+
+func TestValidateNamespaceTemplate(t *testing.T) {
+	cfg := validConfig()
+	cfg.NamespaceTemplate = "team-{{ .Claims.team }}"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Unexpected error for a valid template: %v", err)
+	}
+
+	cfg.NamespaceTemplate = "team-{{ .Claims.team"
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected error for an invalid template but got none")
+	}
+}
+
+func TestValidateKubeconfigNames(t *testing.T) {
+	cfg := validConfig()
+	cfg.KubeconfigUserName = "{{ .Claims.email }}-{{ .ClusterName }}"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Unexpected error for a valid template: %v", err)
+	}
+
+	cfg.KubeconfigContextName = "{{ if .Namespace }}"
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected error for an invalid template but got none")
+	}
+}
+
+func TestDurationConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "gangway-config-test")
+	if err != nil {
+		t.Fatalf("Error creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprint(f, "downloadLinkTTL: 90s\n")
+	f.Close()
+
+	os.Setenv("GANGWAY_PROVIDER_URL", "https://foo.bar")
+	os.Setenv("GANGWAY_APISERVER_URL", "https://k8s-api.foo.baz")
+	os.Setenv("GANGWAY_CLIENT_ID", "foo")
+	os.Setenv("GANGWAY_CLIENT_SECRET", "bar")
+	os.Setenv("GANGWAY_REDIRECT_URL", "https://foo.baz/callback")
+	os.Setenv("GANGWAY_SESSION_SECURITY_KEY", "testing")
+	os.Setenv("GANGWAY_SESSION_SALT", "0123456789")
+	os.Setenv("GANGWAY_CLUSTER_CA_PATH", "")
+
+	cfg, err := NewConfig(f.Name())
+	if err != nil {
+		t.Fatalf("Failed to parse config: %s", err)
+	}
+	if cfg.DownloadLinkTTL.Duration != 90*time.Second {
+		t.Errorf("Failed to read duration from config file. Expected %s but got %s", 90*time.Second, cfg.DownloadLinkTTL)
+	}
+
+	os.Setenv("GANGWAY_DOWNLOAD_LINK_TTL", "10m")
+	defer os.Unsetenv("GANGWAY_DOWNLOAD_LINK_TTL")
+	cfg, err = NewConfig(f.Name())
+	if err != nil {
+		t.Fatalf("Failed to parse config: %s", err)
+	}
+	if cfg.DownloadLinkTTL.Duration != 10*time.Minute {
+		t.Errorf("Failed to override duration with environment. Expected %s but got %s", 10*time.Minute, cfg.DownloadLinkTTL)
+	}
+}
+
+func TestValidateSnippetMode(t *testing.T) {
+	cfg := validConfig()
+	cfg.SnippetMode = SnippetModeBootstrap
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected error for bootstrap snippets without a download link TTL but got none")
+	}
+
+	cfg.DownloadLinkTTL = Duration{time.Minute}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Unexpected error for bootstrap snippets: %v", err)
+	}
+	if !cfg.UseDownloadLinks() {
+		t.Errorf("Expected bootstrap snippets to use download links")
+	}
+
+	cfg.SnippetMode = "paste"
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected error for an unknown snippet mode but got none")
+	}
+}
+
+func TestPublicClient(t *testing.T) {
+	cfg := validConfig()
+	if cfg.KubeconfigClientID() != "foo" || cfg.KubeconfigClientSecret() != "bar" {
+		t.Errorf("Expected gangway's client without a public client, got %q/%q", cfg.KubeconfigClientID(), cfg.KubeconfigClientSecret())
+	}
+	if len(cfg.Warnings()) != 0 {
+		t.Errorf("Unexpected warnings: %v", cfg.Warnings())
+	}
+
+	cfg.PublicClientExecPlugin = true
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected error for the exec plugin without a public client but got none")
+	}
+
+	cfg.PublicClientID = "kubectl"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Unexpected error for a public client: %v", err)
+	}
+	if cfg.KubeconfigClientID() != "kubectl" || cfg.KubeconfigClientSecret() != "" {
+		t.Errorf("Expected the public client without secret, got %q/%q", cfg.KubeconfigClientID(), cfg.KubeconfigClientSecret())
+	}
+	if len(cfg.Warnings()) != 0 {
+		t.Errorf("Unexpected warnings: %v", cfg.Warnings())
+	}
+
+	cfg.PublicClientID = cfg.ClientID
+	if len(cfg.Warnings()) != 1 {
+		t.Errorf("Expected a warning when the public client is gangway's client, got %v", cfg.Warnings())
+	}
+}
+
+func TestValidatePublicClientRefreshToken(t *testing.T) {
+	// kubeconfigs would pair the public client with the refresh token of gangway's client
+	cfg := validConfig()
+	cfg.PublicClientID = "kubectl"
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected error for a public client without exec plugin but got none")
+	}
+
+	// the exchange authenticates as gangway's client, so do its refresh tokens
+	cfg.TokenExchangeAudience = "cluster"
+	cfg.TokenExchangeTokenType = TokenTypeIDToken
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected error for a public client with token exchange but got none")
+	}
+}
+
+func TestValidateTokenExchange(t *testing.T) {
+	cfg := validConfig()
+	cfg.TokenExchangeAudience = "cluster"
+	cfg.TokenExchangeTokenType = TokenTypeAccessToken
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Unexpected error for a token exchange: %v", err)
+	}
+
+	cfg.TokenExchangeTokenType = "saml2"
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected error for an unknown token type but got none")
+	}
+
+	cfg.TokenExchangeTokenType = TokenTypeIDToken
+	cfg.PublicClientID = "kubectl"
+	cfg.PublicClientExecPlugin = true
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected error for a token exchange with the exec plugin but got none")
+	}
+}
+
+func TestValidateCertificateBackend(t *testing.T) {
+	cfg := validConfig()
+	cfg.CredentialBackend = CredentialBackendCertificate
+	cfg.CertificateSignerName = "kubernetes.io/kube-apiserver-client"
+	cfg.CertificateTTL = Duration{time.Hour}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected error for certificates without approve groups but got none")
+	}
+
+	cfg.CertificateApproveGroups = []string{"breakglass"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Unexpected error for the certificate backend: %v", err)
+	}
+
+	cfg.CertificateTTL = Duration{time.Minute}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected error for a certificate TTL below the minimum of the CSR API but got none")
+	}
+
+	cfg.CertificateTTL = Duration{time.Hour}
+	cfg.TokenExchangeAudience = "cluster"
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected error for certificates with a token exchange but got none")
+	}
+
+	cfg.TokenExchangeAudience = ""
+	cfg.CredentialBackend = "password"
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected error for an unknown credential backend but got none")
+	}
+}
+
+func TestValidateServiceAccountBackend(t *testing.T) {
+	cfg := validConfig()
+	cfg.CredentialBackend = CredentialBackendServiceAccount
+	cfg.ServiceAccountTokenTTL = Duration{time.Hour}
+	cfg.ServiceAccountGroups = map[string]string{"ops": "ops-admin"}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected error for ServiceAccount tokens without a namespace but got none")
+	}
+
+	cfg.ServiceAccountNamespace = "gangway-users"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Unexpected error for the serviceaccount backend: %v", err)
+	}
+
+	cfg.ServiceAccountTokenTTL = Duration{5 * time.Minute}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected error for a token TTL below the minimum of the TokenRequest API but got none")
+	}
+}
+
+func TestSecurityHeaderConfig(t *testing.T) {
+	cfg := validConfig()
+	cfg.HSTSMaxAge = Duration{-time.Second}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected a negative hstsMaxAge to be invalid")
+	}
+
+	cfg = validConfig()
+	cfg.ContentSecurityPolicy = "default-src 'self'\r\nSet-Cookie: x=y"
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected a header with a line break to be invalid")
+	}
+
+	tests := map[string]struct {
+		serveTLS    bool
+		redirectURL string
+		want        bool
+	}{
+		"tls":   {serveTLS: true, redirectURL: "http://localhost:8080/callback", want: true},
+		"proxy": {redirectURL: "HTTPS://gangway.example.com/callback", want: true},
+		"plain": {redirectURL: "http://localhost:8080/callback", want: false},
+	}
+	for name, tc := range tests {
+		cfg := &Config{ServeTLS: tc.serveTLS, RedirectURL: tc.redirectURL}
+		if got := cfg.ServesHTTPS(); got != tc.want {
+			t.Errorf("%s: want ServesHTTPS %v, got %v", name, tc.want, got)
+		}
+	}
+}
+
+func TestCookieConfig(t *testing.T) {
+	cfg := validConfig()
+	if !cfg.SecureCookies() {
+		t.Errorf("Expected secure cookies for an HTTPS redirectURL")
+	}
+	if cfg.SessionCookiePath() != "/" {
+		t.Errorf("Expected the cookies on /, got %s", cfg.SessionCookiePath())
+	}
+
+	cfg.HTTPPath = "/gangway"
+	if cfg.SessionCookiePath() != "/gangway" {
+		t.Errorf("Expected the cookies on the httpPath, got %s", cfg.SessionCookiePath())
+	}
+	cfg.CookiePath = "/"
+	if cfg.SessionCookiePath() != "/" {
+		t.Errorf("Expected the configured cookiePath, got %s", cfg.SessionCookiePath())
+	}
+
+	insecure := false
+	cfg.CookieSecure = &insecure
+	if cfg.SecureCookies() {
+		t.Errorf("Expected cookieSecure to override the default")
+	}
+	if len(cfg.Warnings()) == 0 {
+		t.Errorf("Expected a warning for insecure cookies over HTTPS")
+	}
+
+	cfg.CookieSameSite = CookieSameSiteNone
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected SameSite none without secure cookies to be invalid")
+	}
+
+	cfg = validConfig()
+	cfg.CookieSameSite = "relaxed"
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected an unknown cookieSameSite to be invalid")
+	}
+
+	cfg = validConfig()
+	cfg.CookieMaxSize = MaxCookieMaxSize + 1
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected a cookieMaxSize beyond what the session store encodes to be invalid")
+	}
+
+	cfg = validConfig()
+	cfg.RedirectURL = "http://localhost:8080/callback"
+	if cfg.SecureCookies() {
+		t.Errorf("Expected no secure cookies for a plain HTTP redirectURL")
+	}
+}
+
+func TestSessionSecurityKeys(t *testing.T) {
+	cfg := validConfig()
+	if keys := cfg.SessionSecurityKeys(); len(keys) != 1 || keys[0] != "testing" {
+		t.Errorf("Expected only the current key, got %v", keys)
+	}
+
+	cfg.PreviousSessionSecurityKeys = []string{"older", "oldest"}
+	keys := cfg.SessionSecurityKeys()
+	if len(keys) != 3 || keys[0] != "testing" || keys[2] != "oldest" {
+		t.Errorf("Expected the current key first, got %v", keys)
+	}
+
+	cfg.PreviousSessionSecurityKeys = []string{""}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected an empty previous key to be invalid")
+	}
+}
+
+func TestTrustedProxyNets(t *testing.T) {
+	cfg := validConfig()
+	cfg.TrustedProxies = []string{"10.0.0.0/8", "192.168.1.1", "fd00::1"}
+	nets, err := cfg.TrustedProxyNets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"10.0.0.0/8", "192.168.1.1/32", "fd00::1/128"} {
+		if nets[i].String() != want {
+			t.Errorf("want %s, got %s", want, nets[i])
+		}
+	}
+
+	for _, proxy := range []string{"10.0.0.0/33", "proxy.example.com"} {
+		cfg.TrustedProxies = []string{proxy}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Expected trustedProxies %q to be invalid", proxy)
+		}
+	}
+
+	cfg = validConfig()
+	cfg.RateLimit = 30
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected a rateLimit without a burst to be invalid")
+	}
+}
+
+func TestExternalBaseURLs(t *testing.T) {
+	cfg := validConfig()
+	cfg.ExternalURLs = []string{"https://Gangway.Example.com/", "http://gangway.internal:8080"}
+	bases, err := cfg.ExternalBaseURLs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bases) != 2 || bases[0].String() != "https://gangway.example.com" || bases[1].String() != "http://gangway.internal:8080" {
+		t.Errorf("Expected the scheme and host of the externalURLs, got %v", bases)
+	}
+
+	for _, external := range []string{"gangway.example.com", "ftp://gangway.example.com", "https://gangway.example.com/gangway", "https://user@gangway.example.com"} {
+		cfg.ExternalURLs = []string{external}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Expected externalURLs %q to be invalid", external)
+		}
+	}
+}
+
+func TestRateLimitWarning(t *testing.T) {
+	cfg := validConfig()
+	cfg.RateLimit = 30
+	cfg.RateLimitBurst = 10
+	if !containsWarning(cfg.Warnings(), "rateLimit") {
+		t.Errorf("Expected a warning for a rateLimit without trustedProxies")
+	}
+
+	cfg.TrustedProxies = []string{"10.0.0.0/8"}
+	if containsWarning(cfg.Warnings(), "rateLimit") {
+		t.Errorf("Expected no warning with trustedProxies")
+	}
+}
+
+// containsWarning returns whether any of the warnings mentions s
+func containsWarning(warnings []string, s string) bool {
+	for _, w := range warnings {
+		if strings.Contains(w, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,201 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package onetime
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+)
+
+// The Store keeps short-lived records that can be redeemed exactly once with the token
+// returned when they were added. Records are encrypted with a key derived from their
+// token and indexed by a hash of it, so the store never holds anything that would
+// allow redeeming a record or reading its contents without the token. Each record has
+// an owner, and owners adding more records than allowed lose their oldest ones, so a
+// single owner cannot fill the store.
+
+var (
+	// ErrNotFound is returned for unknown tokens and records that were already redeemed
+	ErrNotFound = errors.New("onetime: record not found or already redeemed")
+	// ErrExpired is returned for records that outlived their TTL
+	ErrExpired = errors.New("onetime: record expired")
+	// ErrFull is returned when the store holds too many unredeemed records
+	ErrFull = errors.New("onetime: too many outstanding records")
+)
+
+const tokenLength = 32
+
+type record struct {
+	sealed  []byte
+	owner   string
+	seq     uint64
+	expires time.Time
+}
+
+// Store holds encrypted one-time records in memory
+type Store struct {
+	ttl         time.Duration
+	maxRecords  int
+	maxPerOwner int
+
+	mu      sync.Mutex
+	records map[string]record
+	seq     uint64
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// NewStore returns a Store whose records expire after ttl. At most maxRecords
+// unredeemed records are kept, and at most maxPerOwner of these per owner.
+func NewStore(ttl time.Duration, maxRecords, maxPerOwner int) *Store {
+	return &Store{
+		ttl:         ttl,
+		maxRecords:  maxRecords,
+		maxPerOwner: maxPerOwner,
+		records:     make(map[string]record),
+		now:         time.Now,
+	}
+}
+
+// TTL returns the time records can be redeemed after they were added
+func (s *Store) TTL() time.Duration {
+	return s.ttl
+}
+
+// Put adds a record of owner holding value and returns the token to redeem it with.
+// When owner already has maxPerOwner records, the oldest of these is removed.
+func (s *Store) Put(owner string, value []byte) (string, error) {
+	b := make([]byte, tokenLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	gcm, err := newGCM(token)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, value, nil)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire()
+	s.limitOwner(owner)
+	if len(s.records) >= s.maxRecords {
+		return "", ErrFull
+	}
+	s.seq++
+	s.records[recordID(token)] = record{
+		sealed:  sealed,
+		owner:   owner,
+		seq:     s.seq,
+		expires: s.now().Add(s.ttl),
+	}
+	return token, nil
+}
+
+// Take redeems the record for token and removes it from the store
+func (s *Store) Take(token string) ([]byte, error) {
+	id := recordID(token)
+
+	s.mu.Lock()
+	rec, ok := s.records[id]
+	delete(s.records, id)
+	expired := s.now().After(rec.expires)
+	s.mu.Unlock()
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if expired {
+		return nil, ErrExpired
+	}
+
+	gcm, err := newGCM(token)
+	if err != nil {
+		return nil, err
+	}
+	nonceSize := gcm.NonceSize()
+	if len(rec.sealed) < nonceSize {
+		return nil, ErrNotFound
+	}
+	value, err := gcm.Open(nil, rec.sealed[:nonceSize], rec.sealed[nonceSize:], nil)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+// expire removes expired records, the caller must hold the lock
+func (s *Store) expire() {
+	now := s.now()
+	for id, rec := range s.records {
+		if now.After(rec.expires) {
+			delete(s.records, id)
+		}
+	}
+}
+
+// limitOwner removes the oldest record of owner when it has maxPerOwner records, making
+// room for another one, the caller must hold the lock
+func (s *Store) limitOwner(owner string) {
+	count, oldest := 0, ""
+	for id, rec := range s.records {
+		if rec.owner != owner {
+			continue
+		}
+		count++
+		if oldest == "" || rec.seq < s.records[oldest].seq {
+			oldest = id
+		}
+	}
+	if count >= s.maxPerOwner && oldest != "" {
+		delete(s.records, oldest)
+	}
+}
+
+// recordID returns the key a record is stored under
+func recordID(token string) string {
+	return string(derive(token, "id"))
+}
+
+// newGCM returns the cipher for the record of token
+func newGCM(token string) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(derive(token, "key"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// derive returns a 32 byte value for the given purpose from token
+func derive(token, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,153 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package onetime
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPutAndTake(t *testing.T) {
+	s := NewStore(time.Minute, 10, 10)
+	value := []byte("kubeconfig")
+
+	token, err := s.Put("alice", value)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	got, err := s.Take(token)
+	if err != nil {
+		t.Fatalf("Take failed: %v", err)
+	}
+	if !bytes.Equal(got, value) {
+		t.Errorf("Take returned %q, want %q", got, value)
+	}
+
+	if _, err = s.Take(token); err != ErrNotFound {
+		t.Errorf("second Take: want %v, got %v", ErrNotFound, err)
+	}
+}
+
+func TestTakeUnknownToken(t *testing.T) {
+	s := NewStore(time.Minute, 10, 10)
+	if _, err := s.Put("alice", []byte("kubeconfig")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	if _, err := s.Take("bogus"); err != ErrNotFound {
+		t.Errorf("Take: want %v, got %v", ErrNotFound, err)
+	}
+}
+
+func TestTakeExpired(t *testing.T) {
+	now := time.Now()
+	s := NewStore(time.Minute, 10, 10)
+	s.now = func() time.Time { return now }
+
+	token, err := s.Put("alice", []byte("kubeconfig"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err = s.Take(token); err != ErrExpired {
+		t.Errorf("Take: want %v, got %v", ErrExpired, err)
+	}
+	if _, err = s.Take(token); err != ErrNotFound {
+		t.Errorf("Take after expiry: want %v, got %v", ErrNotFound, err)
+	}
+}
+
+func TestStoreFull(t *testing.T) {
+	now := time.Now()
+	s := NewStore(time.Minute, 2, 2)
+	s.now = func() time.Time { return now }
+
+	for _, owner := range []string{"alice", "bob"} {
+		if _, err := s.Put(owner, []byte("kubeconfig")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if _, err := s.Put("carol", []byte("kubeconfig")); err != ErrFull {
+		t.Errorf("Put: want %v, got %v", ErrFull, err)
+	}
+
+	// expired records make room again
+	now = now.Add(2 * time.Minute)
+	if _, err := s.Put("carol", []byte("kubeconfig")); err != nil {
+		t.Errorf("Put after expiry failed: %v", err)
+	}
+}
+
+func TestMaxPerOwner(t *testing.T) {
+	s := NewStore(time.Minute, 10, 2)
+
+	var tokens []string
+	for i := 0; i < 3; i++ {
+		token, err := s.Put("alice", []byte("kubeconfig"))
+		if err != nil {
+			t.Fatalf("Put %d failed: %v", i+1, err)
+		}
+		tokens = append(tokens, token)
+	}
+	if len(s.records) != 2 {
+		t.Errorf("want 2 records for a single owner, got %d", len(s.records))
+	}
+
+	// the oldest record makes room for the newest
+	if _, err := s.Take(tokens[0]); err != ErrNotFound {
+		t.Errorf("Take of the oldest record: want %v, got %v", ErrNotFound, err)
+	}
+	for _, token := range tokens[1:] {
+		if _, err := s.Take(token); err != nil {
+			t.Errorf("Take of a recent record failed: %v", err)
+		}
+	}
+
+	// other owners keep their records
+	bob, err := s.Put("bob", []byte("kubeconfig"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := s.Put("alice", []byte("kubeconfig")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if _, err := s.Take(bob); err != nil {
+		t.Errorf("Take of another owner's record failed: %v", err)
+	}
+}
+
+func TestRecordsAreEncrypted(t *testing.T) {
+	s := NewStore(time.Minute, 10, 10)
+	value := []byte("secret-refresh-token")
+
+	token, err := s.Put("alice", value)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	for id, rec := range s.records {
+		if bytes.Contains([]byte(id), []byte(token)) {
+			t.Errorf("record is indexed by its token")
+		}
+		if bytes.Contains(rec.sealed, value) {
+			t.Errorf("record holds its value in plain text")
+		}
+	}
+}
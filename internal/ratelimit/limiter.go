@@ -0,0 +1,111 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// The Limiter keeps a token bucket per client. Every request takes a token, buckets refill
+// at a steady rate up to the burst size, and clients whose bucket is full again are
+// forgotten, so the memory used only depends on the clients that were recently active.
+// When too many clients are active, the fullest bucket makes room: it is the one closest
+// to not being tracked at all, so a flood of new clients cannot lock out everyone else.
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter limits the requests of each client to a rate with bursts
+type Limiter struct {
+	rate       float64 // tokens per second
+	burst      float64
+	maxClients int
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// NewLimiter returns a Limiter allowing each client perMinute requests a minute, with bursts
+// of up to burst requests. At most maxClients clients are tracked at once.
+func NewLimiter(perMinute, burst, maxClients int) *Limiter {
+	return &Limiter{
+		rate:       float64(perMinute) / 60,
+		burst:      float64(burst),
+		maxClients: maxClients,
+		buckets:    make(map[string]*bucket),
+		now:        time.Now,
+	}
+}
+
+// Allow takes a token from the bucket of client. When the bucket is empty it returns false
+// and the time until the next token is available.
+func (l *Limiter) Allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= l.maxClients {
+			l.expire(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+
+	b.tokens = l.refill(b, now)
+	b.last = now
+	if b.tokens < 1 {
+		return false, l.wait(b.tokens)
+	}
+	b.tokens--
+	return true, 0
+}
+
+// refill returns the tokens in b at now
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	return math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+}
+
+// wait returns the time until a bucket holding tokens has a full token
+func (l *Limiter) wait(tokens float64) time.Duration {
+	return time.Duration(math.Ceil((1 - tokens) / l.rate * float64(time.Second)))
+}
+
+// expire forgets the clients whose bucket is full again, or else the client with the
+// fullest bucket, the caller must hold the lock
+func (l *Limiter) expire(now time.Time) {
+	fullest, most := "", -1.0
+	for client, b := range l.buckets {
+		tokens := l.refill(b, now)
+		if tokens >= l.burst {
+			delete(l.buckets, client)
+			continue
+		}
+		if tokens > most || tokens == most && b.last.Before(l.buckets[fullest].last) {
+			fullest, most = client, tokens
+		}
+	}
+	if len(l.buckets) >= l.maxClients {
+		delete(l.buckets, fullest)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	now := time.Now()
+	l := NewLimiter(60, 3, 10)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("10.0.0.1"); !ok {
+			t.Fatalf("request %d of the burst was refused", i+1)
+		}
+	}
+	ok, wait := l.Allow("10.0.0.1")
+	if ok {
+		t.Fatal("expected the request after the burst to be refused")
+	}
+	if wait != time.Second {
+		t.Errorf("want a wait of 1s, got %v", wait)
+	}
+
+	// other clients have their own bucket
+	if ok, _ := l.Allow("10.0.0.2"); !ok {
+		t.Error("expected another client to be allowed")
+	}
+
+	now = now.Add(1500 * time.Millisecond)
+	if ok, _ := l.Allow("10.0.0.1"); !ok {
+		t.Error("expected a request to be allowed once a token was refilled")
+	}
+	ok, wait = l.Allow("10.0.0.1")
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("want a refusal with a wait of 500ms, got %v and %v", ok, wait)
+	}
+}
+
+func TestAllowMaxClients(t *testing.T) {
+	now := time.Now()
+	l := NewLimiter(60, 2, 2)
+	l.now = func() time.Time { return now }
+
+	l.Allow("10.0.0.1")
+	l.Allow("10.0.0.2")
+	l.Allow("10.0.0.2")
+
+	// a new client takes the place of the fullest bucket
+	if ok, _ := l.Allow("10.0.0.3"); !ok {
+		t.Error("expected a new client to be allowed while the limiter is full")
+	}
+	if _, ok := l.buckets["10.0.0.1"]; ok || len(l.buckets) != 2 {
+		t.Errorf("expected the fullest bucket to be forgotten, got %v", l.buckets)
+	}
+	if ok, _ := l.Allow("10.0.0.2"); ok {
+		t.Error("expected the emptied bucket to stay limited")
+	}
+
+	// clients whose bucket refilled are forgotten to make room
+	now = now.Add(2 * time.Second)
+	if ok, _ := l.Allow("10.0.0.4"); !ok {
+		t.Error("expected a new client to be allowed after the others refilled")
+	}
+	if len(l.buckets) != 1 {
+		t.Errorf("want 1 tracked client, got %d", len(l.buckets))
+	}
+}
+
+func TestAllowFlood(t *testing.T) {
+	now := time.Now()
+	l := NewLimiter(60, 5, 100)
+	l.now = func() time.Time { return now }
+
+	// clients rotating through many addresses do not lock out others
+	for i := 0; i < 1000; i++ {
+		l.Allow(fmt.Sprintf("2001:db8:%x::", i))
+	}
+	if ok, _ := l.Allow("192.0.2.1"); !ok {
+		t.Error("expected a client to be allowed during a flood of new clients")
+	}
+	if len(l.buckets) > 100 {
+		t.Errorf("expected at most 100 tracked clients, got %d", len(l.buckets))
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package session
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gorilla/securecookie"
+)
+
+const (
+	// compressedMarker starts compressed session values. Gob streams start with a message
+	// length, which is either below 0x80 or a negated byte count of 0xf8 and up, so the
+	// marker cannot be mistaken for the start of a plain value.
+	compressedMarker = 0xc7
+	// compressedVersion is the format of the compressed values following the marker
+	compressedVersion = 1
+	// maxDecompressedSize guards against values that inflate to absurd sizes
+	maxDecompressedSize = 1 << 20
+)
+
+// compressingSerializer gob encodes session values and, when enabled, compresses them
+// before securecookie encrypts them. Compressed and plain values both decode, so cookies
+// stay valid when compression is switched on or off.
+type compressingSerializer struct {
+	gob      securecookie.GobEncoder
+	compress bool
+}
+
+// Serialize encodes src, compressed if enabled and if that makes it smaller
+func (s *compressingSerializer) Serialize(src interface{}) ([]byte, error) {
+	plain, err := s.gob.Serialize(src)
+	if err != nil || !s.compress {
+		return plain, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write([]byte{compressedMarker, compressedVersion})
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(plain); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() >= len(plain) {
+		return plain, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// Deserialize decodes src into dst, inflating it first if it was compressed
+func (s *compressingSerializer) Deserialize(src []byte, dst interface{}) error {
+	if len(src) == 0 || src[0] != compressedMarker {
+		return s.gob.Deserialize(src, dst)
+	}
+	if len(src) < 2 || src[1] != compressedVersion {
+		return errors.New("session: unknown compression format")
+	}
+
+	r := flate.NewReader(bytes.NewReader(src[2:]))
+	defer r.Close()
+	plain, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+	if err != nil {
+		return fmt.Errorf("session: failed to decompress: %v", err)
+	}
+	if len(plain) > maxDecompressedSize {
+		return errors.New("session: decompressed value too large")
+	}
+	return s.gob.Deserialize(plain, dst)
+}
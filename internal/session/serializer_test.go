@@ -0,0 +1,191 @@
+// Copyright © 2017 Heptio
+// Copyright © 2017 Craig Tracey
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package session
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	mathrand "math/rand"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+)
+
+// largeIDToken returns an ID token like the ones of identity providers that put many groups in it
+func largeIDToken(groups int) string {
+	claims := map[string]interface{}{
+		"iss":   "https://login.microsoftonline.com/6f2b3e3c-7d1a-4c7e-9a4b-2f1d5c3b8a90/v2.0",
+		"sub":   "AAAAAAAAAAAAAAAAAAAAAIkzqFVrSaSaFHy782bbtaQ",
+		"aud":   "6731de76-14a6-49ae-97bc-6eba6914391e",
+		"exp":   1700003600,
+		"iat":   1700000000,
+		"name":  "Alice Example",
+		"email": "alice@example.com",
+	}
+	// group object IDs and the signature are random, seeded for stable sizes
+	random := mathrand.New(mathrand.NewSource(int64(groups)))
+	var g []string
+	for i := 0; i < groups; i++ {
+		g = append(g, fmt.Sprintf("%08x-%04x-%04x-%04x-%012x", random.Uint32(), random.Intn(1<<16),
+			random.Intn(1<<16), random.Intn(1<<16), random.Int63n(1<<48)))
+	}
+	claims["groups"] = g
+	payload, _ := json.Marshal(claims)
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"key-1","typ":"JWT"}`))
+	sig := make([]byte, 256)
+	random.Read(sig)
+	signature := base64.RawURLEncoding.EncodeToString(sig)
+	return header + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + signature
+}
+
+func TestCompressingSerializer(t *testing.T) {
+	token := largeIDToken(100)
+	values := map[interface{}]interface{}{"id_token": token}
+
+	plain := &compressingSerializer{}
+	compressed := &compressingSerializer{compress: true}
+
+	plainData, err := plain.Serialize(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressedData, err := compressed.Serialize(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compressedData[0] != compressedMarker || compressedData[1] != compressedVersion {
+		t.Fatalf("expected a compressed value to start with the marker, got % x", compressedData[:2])
+	}
+	if len(compressedData) >= len(plainData) {
+		t.Errorf("expected compression to shrink the value, got %d bytes from %d", len(compressedData), len(plainData))
+	}
+
+	// both serializers decode both forms
+	for name, data := range map[string][]byte{"plain": plainData, "compressed": compressedData} {
+		for _, s := range []*compressingSerializer{plain, compressed} {
+			var decoded map[interface{}]interface{}
+			if err := s.Deserialize(data, &decoded); err != nil {
+				t.Errorf("%s value, compress %v: %v", name, s.compress, err)
+				continue
+			}
+			if decoded["id_token"] != token {
+				t.Errorf("%s value, compress %v: token changed in the round trip", name, s.compress)
+			}
+		}
+	}
+}
+
+func TestCompressingSerializerIncompressible(t *testing.T) {
+	s := &compressingSerializer{compress: true}
+	random := make([]byte, 64)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	data, err := s.Serialize(random)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] == compressedMarker {
+		t.Errorf("expected values that do not shrink to be stored as they are")
+	}
+	var decoded []byte
+	if err := s.Deserialize(data, &decoded); err != nil || !bytes.Equal(decoded, random) {
+		t.Errorf("expected the value to survive the round trip, got %v", err)
+	}
+}
+
+func TestCompressingSerializerInvalid(t *testing.T) {
+	s := &compressingSerializer{}
+	var dst map[interface{}]interface{}
+
+	if err := s.Deserialize([]byte{compressedMarker, 99, 0}, &dst); err == nil {
+		t.Errorf("expected an unknown version to fail")
+	}
+
+	// a value inflating beyond the limit
+	var buf bytes.Buffer
+	buf.Write([]byte{compressedMarker, compressedVersion})
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	_, _ = w.Write(make([]byte, maxDecompressedSize+10))
+	_ = w.Close()
+	err := s.Deserialize(buf.Bytes(), &dst)
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("expected an oversized value to fail, got %v", err)
+	}
+}
+
+func TestCompressedCookiesAfterToggle(t *testing.T) {
+	store := NewCustomCookieStore([]byte("hash-key"), []byte("0123456789abcdef"))
+	token := largeIDToken(100)
+
+	// a cookie from before compression was enabled
+	r := httptest.NewRequest("GET", "/", nil)
+	session, _ := store.New(r, "gangway_id_token")
+	session.Values["id_token"] = token
+	rec := httptest.NewRecorder()
+	if err := store.Save(r, rec, session); err != nil {
+		t.Fatal(err)
+	}
+
+	store.SetCompression(true)
+	r = requestWithCookies(rec.Result().Cookies())
+	session, err := store.New(r, "gangway_id_token")
+	if err != nil || session.Values["id_token"] != token {
+		t.Fatalf("expected an uncompressed cookie to decode, got %v", err)
+	}
+
+	rec = httptest.NewRecorder()
+	if err := store.Save(r, rec, session); err != nil {
+		t.Fatal(err)
+	}
+	store.SetCompression(false)
+	session, err = store.New(requestWithCookies(rec.Result().Cookies()), "gangway_id_token")
+	if err != nil || session.Values["id_token"] != token {
+		t.Fatalf("expected a compressed cookie to decode, got %v", err)
+	}
+}
+
+// BenchmarkCookieSize reports the size of the gangway_id_token cookies for ID tokens with
+// a growing number of groups, with and without compression
+func BenchmarkCookieSize(b *testing.B) {
+	for _, groups := range []int{10, 100, 200} {
+		for _, compress := range []bool{false, true} {
+			b.Run(fmt.Sprintf("groups=%d/compress=%v", groups, compress), func(b *testing.B) {
+				codecs := securecookie.CodecsFromPairs([]byte("hash-key"), []byte("0123456789abcdef"))
+				for _, codec := range codecs {
+					codec.(*securecookie.SecureCookie).MaxLength(81920).SetSerializer(&compressingSerializer{compress: compress})
+				}
+				values := map[interface{}]interface{}{"id_token": largeIDToken(groups)}
+
+				var size int
+				for i := 0; i < b.N; i++ {
+					encoded, err := securecookie.EncodeMulti("gangway_id_token", values, codecs...)
+					if err != nil {
+						b.Fatal(err)
+					}
+					size = len(encoded)
+				}
+				b.ReportMetric(float64(size), "cookie-bytes")
+				b.ReportMetric(float64(len(splitCookie(strings.Repeat("x", size)))), "cookies")
+			})
+		}
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"crypto/sha256"
 	"net/http"
 
+	"github.com/gorilla/sessions"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/pbkdf2"
 )
@@ -27,10 +28,23 @@ type Session struct {
 	Session *CustomCookieStore
 }
 
-// New inits a Session with CookieStore
-func New(sessionSecurityKey string, sessionSalt string) *Session {
+// New inits a Session with CookieStore. The first key encodes the cookies, the others only
+// decode them, so cookies issued before a key rotation stay valid. The options apply to all
+// cookies of the store, nil keeps the defaults of gorilla/sessions.
+func New(sessionSecurityKeys []string, sessionSalt string, options *sessions.Options) *Session {
+	var keyPairs [][]byte
+	for _, key := range sessionSecurityKeys {
+		hashKey, blockKey := generateSessionKeys(key, sessionSalt)
+		keyPairs = append(keyPairs, hashKey, blockKey)
+	}
+
+	store := NewCustomCookieStore(keyPairs...)
+	if options != nil {
+		opts := *options
+		store.Options = &opts
+	}
 	return &Session{
-		Session: NewCustomCookieStore(generateSessionKeys(sessionSecurityKey, sessionSalt)),
+		Session: store,
 	}
 }
 
@@ -49,10 +63,10 @@ func generateSessionKeys(sessionSecurityKey string, salt string) ([]byte, []byte
 
 // Cleanup removes the current session from the store
 func (s *Session) Cleanup(w http.ResponseWriter, r *http.Request, name string) {
+	// cookies that no longer decode, e.g. after their key was retired, are removed as well
 	session, err := s.Session.Get(r, name)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		log.Debugf("removing session %s that failed to decode: %v", name, err)
 	}
 	session.Options.MaxAge = -1
 	err = session.Save(r, w)
@@ -33,7 +33,7 @@ func TestGenerateSessionKeys(t *testing.T) {
 }
 
 func TestInitSessionStore(t *testing.T) {
-	s := New("testing", "0123456789")
+	s := New([]string{"testing"}, "0123456789", nil)
 	if s.Session == nil {
 		t.Errorf("Session Store is nil. Did not get initialized")
 		return
@@ -42,7 +42,7 @@ func TestInitSessionStore(t *testing.T) {
 }
 
 func TestCleanupSession(t *testing.T) {
-	s := New("testing", "0123456789")
+	s := New([]string{"testing"}, "0123456789", nil)
 	session := &sessions.Session{}
 	// create a test http server
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -59,3 +59,129 @@ func TestCleanupSession(t *testing.T) {
 		t.Errorf("Session was not reset. Have max age of %d. Should have -1", session.Options.MaxAge)
 	}
 }
+
+func TestSessionOptions(t *testing.T) {
+	s := New([]string{"testing"}, "0123456789", &sessions.Options{
+		Path:     "/gangway",
+		Domain:   "example.com",
+		MaxAge:   3600,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	r := httptest.NewRequest("GET", "/gangway/commandline", nil)
+	session, _ := s.Session.Get(r, "gangway_id_token")
+	// large enough to be split into several cookies
+	session.Values["id_token"] = randStringBytesRmndr(3 * maxCookieLength)
+	rec := httptest.NewRecorder()
+	if err := session.Save(r, rec); err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) < 2 {
+		t.Fatalf("expected the session to be split, got %d cookies", len(cookies))
+	}
+	for _, c := range cookies {
+		if c.Path != "/gangway" || c.Domain != "example.com" || c.MaxAge != 3600 || !c.Secure || !c.HttpOnly || c.SameSite != http.SameSiteLaxMode {
+			t.Errorf("cookie %s does not have the configured attributes: %+v", c.Name, c)
+		}
+	}
+}
+
+func TestCleanupSectionCookies(t *testing.T) {
+	s := New([]string{"testing"}, "0123456789", &sessions.Options{Path: "/gangway", Secure: true, HttpOnly: true})
+
+	r := httptest.NewRequest("GET", "/gangway/commandline", nil)
+	session, _ := s.Session.Get(r, "gangway_id_token")
+	session.Values["id_token"] = randStringBytesRmndr(2 * maxCookieLength)
+	rec := httptest.NewRecorder()
+	if err := session.Save(r, rec); err != nil {
+		t.Fatal(err)
+	}
+
+	r = httptest.NewRequest("GET", "/gangway/logout", nil)
+	for _, c := range rec.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	rec = httptest.NewRecorder()
+	s.Cleanup(rec, r, "gangway_id_token")
+
+	expired := make(map[string]bool)
+	for _, c := range rec.Result().Cookies() {
+		if c.MaxAge >= 0 || c.Path != "/gangway" || !c.Secure {
+			t.Errorf("expected cookie %s to be expired with the configured attributes, got %+v", c.Name, c)
+		}
+		expired[c.Name] = true
+	}
+	for _, name := range []string{"gangway_id_token", "gangway_id_token_0", "gangway_id_token_1", "gangway_id_token_2"} {
+		if !expired[name] {
+			t.Errorf("expected cookie %s to be expired, got %v", name, rec.Result().Cookies())
+		}
+	}
+}
+
+func TestSessionKeyRotation(t *testing.T) {
+	old := New([]string{"old-key"}, "0123456789", nil)
+	rotated := New([]string{"new-key", "old-key"}, "0123456789", nil)
+	retired := New([]string{"new-key"}, "0123456789", nil)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	session, _ := old.Session.Get(r, "gangway_id_token")
+	session.Values["id_token"] = "token"
+	rec := httptest.NewRecorder()
+	if err := session.Save(r, rec); err != nil {
+		t.Fatal(err)
+	}
+
+	// cookies issued with the old key still decode after the rotation
+	r = requestWithCookies(rec.Result().Cookies())
+	session, err := rotated.Session.Get(r, "gangway_id_token")
+	if err != nil {
+		t.Fatalf("expected a cookie encoded with the previous key to decode, got %v", err)
+	}
+	if session.Values["id_token"] != "token" {
+		t.Errorf("expected the session values to survive the rotation, got %v", session.Values)
+	}
+
+	// and are encoded with the new key when saved again
+	rec = httptest.NewRecorder()
+	if err := session.Save(r, rec); err != nil {
+		t.Fatal(err)
+	}
+	r = requestWithCookies(rec.Result().Cookies())
+	session, err = retired.Session.Get(r, "gangway_id_token")
+	if err != nil || session.Values["id_token"] != "token" {
+		t.Errorf("expected the saved cookie to be encoded with the new key, got %v (%v)", session.Values, err)
+	}
+
+	// once the old key is retired, its cookies no longer decode
+	r = httptest.NewRequest("GET", "/", nil)
+	session, _ = old.Session.Get(r, "gangway_id_token")
+	session.Values["id_token"] = "token"
+	rec = httptest.NewRecorder()
+	if err := session.Save(r, rec); err != nil {
+		t.Fatal(err)
+	}
+	r = requestWithCookies(rec.Result().Cookies())
+	if _, err := retired.Session.Get(r, "gangway_id_token"); err == nil {
+		t.Errorf("expected a cookie encoded with a retired key not to decode")
+	}
+
+	// but can still be removed
+	rec = httptest.NewRecorder()
+	retired.Cleanup(rec, r, "gangway_id_token")
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("expected the undecodable cookie to be expired, got %v", cookies)
+	}
+}
+
+func requestWithCookies(cookies []*http.Cookie) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	return r
+}
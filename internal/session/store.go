@@ -14,10 +14,13 @@
 package session
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 // The CustomCookieStore automatically splits cookies with length greater than maxCookieLength into multiple smaller cookies.
@@ -29,18 +32,34 @@ const (
 	maxCookieLength = 3840
 )
 
+// ErrCookieTooLarge is returned by Save when the encoded session exceeds MaxSize
+var ErrCookieTooLarge = errors.New("session cookie too large")
+
 type CustomCookieStore struct {
 	*sessions.CookieStore
+	// MaxSize caps the total size of the encoded session over all of its cookies, 0 means no cap
+	MaxSize int
+
+	serializer *compressingSerializer
 }
 
-// Set secureCookie maxLength to an arbitrary (20x4kb) high value since we are no longer limited
+// Set secureCookie maxLength to an arbitrary (20x4kb) high value since we are no longer limited,
+// config.MaxCookieMaxSize has to follow it
 func NewCustomCookieStore(keyPairs ...[]byte) *CustomCookieStore {
 	cookieStore := sessions.NewCookieStore(keyPairs...)
+	serializer := &compressingSerializer{}
 	for _, codec := range cookieStore.Codecs {
 		cookie := codec.(*securecookie.SecureCookie)
 		cookie.MaxLength(81920)
+		cookie.SetSerializer(serializer)
 	}
-	return &CustomCookieStore{cookieStore}
+	return &CustomCookieStore{CookieStore: cookieStore, serializer: serializer}
+}
+
+// SetCompression sets whether session values are compressed before they are encrypted.
+// Cookies decode either way.
+func (s *CustomCookieStore) SetCompression(compress bool) {
+	s.serializer.compress = compress
 }
 
 func (s *CustomCookieStore) Get(r *http.Request, name string) (*sessions.Session, error) {
@@ -68,31 +87,63 @@ func (s *CustomCookieStore) New(r *http.Request, name string) (*sessions.Session
 
 // If the cookie length is > maxCookieLength, its value is split into multiple cookies
 // fitting into the maxCookieLength limit.
-// The resulting section cookies get their index appended to the name.
+// The resulting section cookies get their index appended to the name. Cookies of an
+// earlier, differently split version of the session are expired, so they cannot end up
+// joined with the new ones.
 func (s *CustomCookieStore) Save(r *http.Request, w http.ResponseWriter,
 	session *sessions.Session) error {
 
+	// Deleting a session expires its cookie and all of its sections
+	if session.Options.MaxAge < 0 {
+		expireCookies(w, session, append([]string{session.Name()}, sectionCookieNames(r, session.Name())...))
+		return nil
+	}
+
 	cookie, err := securecookie.EncodeMulti(session.Name(), session.Values,
 		s.Codecs...)
 	if err != nil {
 		return err
 	}
+	if s.MaxSize > 0 && len(cookie) > s.MaxSize {
+		return fmt.Errorf("%w: session %s needs %d bytes, the limit is %d", ErrCookieTooLarge, session.Name(), len(cookie), s.MaxSize)
+	}
 
 	sectionCookies := splitCookie(cookie)
 	// With a singular section the name is unchanged
 	if len(sectionCookies) == 1 {
 		cookieName := session.Name()
 		http.SetCookie(w, sessions.NewCookie(cookieName, sectionCookies[0], session.Options))
+		expireCookies(w, session, sectionCookieNames(r, session.Name()))
 		return nil
 	}
 
+	var stale []string
+	if _, err := r.Cookie(session.Name()); err == nil {
+		stale = append(stale, session.Name())
+	}
+	for _, name := range sectionCookieNames(r, session.Name()) {
+		if index, _ := sectionCookieIndex(session.Name(), name); index >= len(sectionCookies) {
+			stale = append(stale, name)
+		}
+	}
+
 	for i, value := range sectionCookies {
 		cookieName := buildSectionCookieName(session.Name(), i)
 		http.SetCookie(w, sessions.NewCookie(cookieName, value, session.Options))
 	}
+	expireCookies(w, session, stale)
 	return nil
 }
 
+// expireCookies removes cookies of the session from the browser
+func expireCookies(w http.ResponseWriter, session *sessions.Session, names []string) {
+	opts := *session.Options
+	opts.MaxAge = -1
+	for _, name := range names {
+		http.SetCookie(w, sessions.NewCookie(name, "", &opts))
+	}
+}
+
 // joinCookies concatenates the values of all matching cookies and returns the original, encoded cookievalue string.
 func joinSectionCookies(r *http.Request, name string) string {
 
@@ -113,6 +164,30 @@ func joinSectionCookies(r *http.Request, name string) string {
 	return joinedValue
 }
 
+// sectionCookieNames returns the names of the section cookies the request carries for a session
+func sectionCookieNames(r *http.Request, name string) []string {
+	var names []string
+	for _, c := range r.Cookies() {
+		if _, ok := sectionCookieIndex(name, c.Name); ok {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
+
+// sectionCookieIndex returns the index of a section cookie of the session name
+func sectionCookieIndex(name, cookieName string) (int, bool) {
+	suffix := strings.TrimPrefix(cookieName, name+"_")
+	if suffix == cookieName {
+		return 0, false
+	}
+	index, err := strconv.Atoi(suffix)
+	if err != nil || index < 0 || strconv.Itoa(index) != suffix {
+		return 0, false
+	}
+	return index, true
+}
+
 // splitCookie splits the original encoded cookie value into a slice of cookies which
 // fit within the 4kb cookie limit indexing the cookies from 0
 func splitCookie(cookieValue string) []string {
@@ -14,6 +14,7 @@
 package session
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gorilla/sessions"
 	log "github.com/sirupsen/logrus"
@@ -21,6 +22,7 @@ import (
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"sort"
 	"strings"
 	"testing"
 )
@@ -104,8 +106,148 @@ func TestSplitAndJoin(t *testing.T) {
 	}
 }
 
+func TestSaveRoundTrip(t *testing.T) {
+	tests := map[string][]int{
+		"growing":                 {100, 5000, 12000},
+		"shrinking":               {12000, 5000, 100},
+		"split to single":         {9000, 200},
+		"single to split":         {200, 9000},
+		"shrinking and regrowing": {12000, 100, 8000},
+	}
+
+	for name, sizes := range tests {
+		t.Run(name, func(t *testing.T) {
+			store := NewCustomCookieStore([]byte("hash-key"), []byte("0123456789abcdef"))
+			jar := make(map[string]*http.Cookie)
+			for _, size := range sizes {
+				value := randStringBytesRmndr(size)
+				saveToJar(t, store, jar, value)
+
+				session, err := store.New(requestFromJar(jar), "test")
+				if err != nil {
+					t.Fatalf("size %d: failed to decode session from %v: %v", size, jarNames(jar), err)
+				}
+				if session.Values["value"] != value {
+					t.Errorf("size %d: session value changed in the round trip", size)
+				}
+			}
+		})
+	}
+}
+
+func TestSaveExpiresStaleCookies(t *testing.T) {
+	store := NewCustomCookieStore([]byte("hash-key"), []byte("0123456789abcdef"))
+	jar := make(map[string]*http.Cookie)
+
+	saveToJar(t, store, jar, randStringBytesRmndr(12000))
+	large := len(jar)
+	if _, ok := jar["test"]; ok || large < 3 {
+		t.Fatalf("expected only section cookies, got %v", jarNames(jar))
+	}
+
+	saveToJar(t, store, jar, randStringBytesRmndr(2500))
+	if len(jar) < 2 || len(jar) >= large {
+		t.Fatalf("expected fewer section cookies, got %v", jarNames(jar))
+	}
+	for i := 0; i < len(jar); i++ {
+		if _, ok := jar[buildSectionCookieName("test", i)]; !ok {
+			t.Errorf("expected only the sections of the new session, got %v", jarNames(jar))
+		}
+	}
+
+	saveToJar(t, store, jar, "small")
+	if _, ok := jar["test"]; !ok || len(jar) != 1 {
+		t.Errorf("expected all sections to be expired, got %v", jarNames(jar))
+	}
+}
+
+func TestSaveMaxSize(t *testing.T) {
+	store := NewCustomCookieStore([]byte("hash-key"), []byte("0123456789abcdef"))
+	store.MaxSize = 8192
+
+	r := httptest.NewRequest("GET", "/", nil)
+	session, _ := store.New(r, "test")
+	session.Values["value"] = randStringBytesRmndr(10000)
+	rec := httptest.NewRecorder()
+	err := store.Save(r, rec, session)
+	if !errors.Is(err, ErrCookieTooLarge) {
+		t.Fatalf("expected ErrCookieTooLarge, got %v", err)
+	}
+	if len(rec.Result().Cookies()) != 0 {
+		t.Errorf("expected no cookies to be written, got %v", rec.Result().Cookies())
+	}
+
+	session.Values["value"] = randStringBytesRmndr(4000)
+	if err := store.Save(r, httptest.NewRecorder(), session); err != nil {
+		t.Errorf("expected a session within the limit to be saved, got %v", err)
+	}
+}
+
+func TestSectionCookieIndex(t *testing.T) {
+	tests := map[string]struct {
+		index int
+		ok    bool
+	}{
+		"test_0":    {0, true},
+		"test_12":   {12, true},
+		"test":      {0, false},
+		"test_":     {0, false},
+		"test_01":   {0, false},
+		"test_-1":   {0, false},
+		"test_id_0": {0, false},
+		"other_0":   {0, false},
+	}
+	for name, tc := range tests {
+		index, ok := sectionCookieIndex("test", name)
+		if index != tc.index || ok != tc.ok {
+			t.Errorf("sectionCookieIndex(%q): want %d/%v, got %d/%v", name, tc.index, tc.ok, index, ok)
+		}
+	}
+}
+
 // Utility
 
+// saveToJar saves a session holding value with the cookies of the jar, and applies the
+// resulting cookies to the jar like a browser would
+func saveToJar(t *testing.T, store *CustomCookieStore, jar map[string]*http.Cookie, value string) {
+	t.Helper()
+	r := requestFromJar(jar)
+	session, err := store.New(r, "test")
+	if err != nil {
+		t.Fatalf("failed to decode session from %v: %v", jarNames(jar), err)
+	}
+	session.Values["value"] = value
+	rec := httptest.NewRecorder()
+	if err := store.Save(r, rec, session); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range rec.Result().Cookies() {
+		if c.MaxAge < 0 {
+			delete(jar, c.Name)
+		} else {
+			jar[c.Name] = c
+		}
+	}
+}
+
+func requestFromJar(jar map[string]*http.Cookie) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, c := range jar {
+		r.AddCookie(&http.Cookie{Name: c.Name, Value: c.Value})
+	}
+	return r
+}
+
+func jarNames(jar map[string]*http.Cookie) []string {
+	var names []string
+	for name := range jar {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+
 type handleReq func([]*http.Cookie, *http.Request)
 
 func buildRequestWithCookies(cookies []*http.Cookie, fn handleReq) {
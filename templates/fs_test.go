@@ -9,7 +9,7 @@ import (
 
 func TestTemplateFS(t *testing.T) {
 	t.Run("finds templates", func(t *testing.T) {
-		filenames := []string{"commandline.tmpl", "home.tmpl"}
+		filenames := []string{"bootstrap-ps1.tmpl", "bootstrap-sh.tmpl", "commandline.tmpl", "error.tmpl", "home.tmpl", "rbac.tmpl"}
 		var missing, empty []string
 
 		for _, filename := range filenames {